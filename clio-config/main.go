@@ -5,13 +5,13 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"reflect"
 	"runtime/debug"
 
 	"github.com/FreeFeed/clio-restore/internal/config"
 	"github.com/FreeFeed/clio-restore/internal/dbutil"
+	"github.com/FreeFeed/clio-restore/internal/logging"
 	"github.com/davidmz/mustbe"
 )
 
@@ -25,9 +25,9 @@ type archConfig struct {
 
 // Globals
 var (
-	infoLog  = log.New(os.Stdout, "INFO  ", log.LstdFlags)
-	errorLog = log.New(os.Stdout, "ERROR ", log.LstdFlags)
-	fatalLog = log.New(os.Stdout, "FATAL ", log.LstdFlags)
+	infoLog  = logging.Info
+	errorLog = logging.Error
+	fatalLog = logging.Fatal
 )
 
 func main() {
@@ -49,6 +49,9 @@ func main() {
 		flag.Bool("restore_comments_and_likes", false, "set restore_comments_and_likes flag for user (t or f)")
 	flag.Parse()
 
+	mustbe.OK(logging.Setup("clio-config", os.Stdout))
+	defer logging.Close()
+
 	if flag.Arg(0) == "" {
 		fmt.Fprintln(os.Stderr, "Usage: clio-config [options] username")
 		flag.PrintDefaults()
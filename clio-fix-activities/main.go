@@ -4,22 +4,22 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"runtime/debug"
 	"time"
 
 	"github.com/FreeFeed/clio-restore/internal/config"
 	"github.com/FreeFeed/clio-restore/internal/dbutil"
+	"github.com/FreeFeed/clio-restore/internal/logging"
 	"github.com/davidmz/mustbe"
 	_ "github.com/lib/pq"
 )
 
 // Globals
 var (
-	infoLog  = log.New(os.Stdout, "INFO  ", log.LstdFlags)
-	errorLog = log.New(os.Stdout, "ERROR ", log.LstdFlags)
-	fatalLog = log.New(os.Stdout, "FATAL ", log.LstdFlags)
+	infoLog  = logging.Info
+	errorLog = logging.Error
+	fatalLog = logging.Fatal
 )
 
 const dateFormat = "2006-01-02"
@@ -37,6 +37,9 @@ func main() {
 	flag.StringVar(&cutDateString, "before", "2015-05-01", "fix activities before this date")
 	flag.Parse()
 
+	mustbe.OK(logging.Setup("clio-fix-activities", os.Stdout))
+	defer logging.Close()
+
 	if flag.Arg(0) == "" {
 		fmt.Fprintln(os.Stderr, "Usage: clio-fix-activities [options] username")
 		flag.PrintDefaults()
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+
+	"github.com/FreeFeed/clio-restore/internal/archive"
+	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/thumbs"
+	"github.com/juju/errors"
+)
+
+var (
+	feedInfoRe   = regexp.MustCompile(`^[a-z0-9-]+/_json/data/feedinfo\.js$`)
+	entryRe      = regexp.MustCompile(`^[a-z0-9-]+/_json/data/entries/[0-9a-f]{8}\.js$`)
+	ffMediaURLRe = thumbs.FFMediaURLRe
+)
+
+// archiveStats is the archive statistics
+type archiveStats struct {
+	Owner        string              `json:"owner"`
+	Entries      int                 `json:"entries"`
+	Via          []*clio.ViaStatItem `json:"via"`
+	Years        []*yearCount        `json:"years"`
+	Comments     int                 `json:"comments"`
+	Likes        int                 `json:"likes"`
+	CommentsBy   []*authorCount      `json:"commentsBy"`
+	LikesBy      []*authorCount      `json:"likesBy"`
+	Thumbnails   int                 `json:"thumbnails"`   // all entry thumbnails
+	LocalImages  int                 `json:"localImages"`  // thumbnails stored on FriendFeed
+	RemoteImages int                 `json:"remoteImages"` // thumbnails of the external services
+	Files        int                 `json:"files"`        // attached files
+	Invalid      []string            `json:"invalid"`      // entries that cannot be parsed
+
+	via        map[string]*clio.ViaStatItem
+	years      map[int]int
+	commentsBy map[string]int
+	likesBy    map[string]int
+}
+
+type yearCount struct {
+	Year  int `json:"year"`
+	Count int `json:"count"`
+}
+
+type authorCount struct {
+	Author string `json:"author"`
+	Count  int    `json:"count"`
+}
+
+func newArchiveStats() *archiveStats {
+	return &archiveStats{
+		Invalid:    []string{},
+		via:        make(map[string]*clio.ViaStatItem),
+		years:      make(map[int]int),
+		commentsBy: make(map[string]int),
+		likesBy:    make(map[string]int),
+	}
+}
+
+func inspectArchive(arch *archive.Archive) (*archiveStats, error) {
+	s := newArchiveStats()
+
+	name, ok := arch.Find(feedInfoRe)
+	if !ok {
+		return nil, errors.New("cannot find feedinfo.js")
+	}
+	user := new(clio.UserJSON)
+	if err := arch.ReadJSON(name, user); err != nil {
+		return nil, errors.Annotate(err, "cannot read feed info")
+	}
+	s.Owner = user.UserName
+
+	for _, name := range arch.Names {
+		if !entryRe.MatchString(name) {
+			continue
+		}
+		entry := new(clio.Entry)
+		if err := arch.ReadJSON(name, entry); err != nil {
+			s.Invalid = append(s.Invalid, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		s.addEntry(entry)
+	}
+
+	s.finish()
+	return s, nil
+}
+
+func (s *archiveStats) addEntry(entry *clio.Entry) {
+	s.Entries++
+
+	v := s.via[entry.Via.URL]
+	if v == nil {
+		v = &clio.ViaStatItem{ViaJSON: entry.Via}
+		s.via[entry.Via.URL] = v
+	}
+	v.Count++
+
+	s.years[entry.Date.Year()]++
+
+	for _, c := range entry.Comments {
+		s.Comments++
+		s.commentsBy[c.AuthorName]++
+	}
+	for _, l := range entry.Likes {
+		s.Likes++
+		s.likesBy[l.AuthorName]++
+	}
+
+	for _, t := range entry.Thumbnails {
+		s.Thumbnails++
+		if ffMediaURLRe.MatchString(t.URL) {
+			s.LocalImages++
+		} else {
+			s.RemoteImages++
+		}
+	}
+	s.Files += len(entry.Files)
+}
+
+// finish converts maps to the sorted lists
+func (s *archiveStats) finish() {
+	s.Via = make([]*clio.ViaStatItem, 0, len(s.via))
+	for _, v := range s.via {
+		s.Via = append(s.Via, v)
+	}
+	sort.Slice(s.Via, func(i, j int) bool {
+		if s.Via[i].Count != s.Via[j].Count {
+			return s.Via[i].Count > s.Via[j].Count
+		}
+		return s.Via[i].URL < s.Via[j].URL
+	})
+
+	s.Years = make([]*yearCount, 0, len(s.years))
+	for y, c := range s.years {
+		s.Years = append(s.Years, &yearCount{y, c})
+	}
+	sort.Slice(s.Years, func(i, j int) bool { return s.Years[i].Year < s.Years[j].Year })
+
+	s.CommentsBy = sortedCounts(s.commentsBy)
+	s.LikesBy = sortedCounts(s.likesBy)
+}
+
+func sortedCounts(m map[string]int) []*authorCount {
+	res := make([]*authorCount, 0, len(m))
+	for a, c := range m {
+		res = append(res, &authorCount{a, c})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Author < res[j].Author
+	})
+	return res
+}
+
+// print prints statistics in human-readable form, only top authors are
+// printed
+func (s *archiveStats) print(w io.Writer, top int) {
+	fmt.Fprintf(w, "Archive of @%s\n", s.Owner)
+	fmt.Fprintf(w, "Entries: %d (%d cannot be parsed)\n", s.Entries, len(s.Invalid))
+	fmt.Fprintf(w, "Comments: %d, likes: %d\n", s.Comments, s.Likes)
+	fmt.Fprintf(w, "Images: %d (local: %d, remote: %d), files: %d\n", s.Thumbnails, s.LocalImages, s.RemoteImages, s.Files)
+
+	fmt.Fprintln(w, "\nEntries by source:")
+	for _, v := range s.Via {
+		fmt.Fprintf(w, "  %6d  %s (%s)\n", v.Count, v.Name, v.URL)
+	}
+
+	fmt.Fprintln(w, "\nEntries by year:")
+	for _, y := range s.Years {
+		fmt.Fprintf(w, "  %6d  %d\n", y.Count, y.Year)
+	}
+
+	for _, list := range []struct {
+		Title  string
+		Counts []*authorCount
+	}{
+		{"Comments by author", s.CommentsBy},
+		{"Likes by author", s.LikesBy},
+	} {
+		fmt.Fprintf(w, "\n%s (top %d of %d):\n", list.Title, top, len(list.Counts))
+		for i, a := range list.Counts {
+			if i >= top {
+				break
+			}
+			fmt.Fprintf(w, "  %6d  %s\n", a.Count, a.Author)
+		}
+	}
+
+	if len(s.Invalid) > 0 {
+		fmt.Fprintln(w)
+	}
+	for _, e := range s.Invalid {
+		fmt.Fprintln(w, "Invalid entry:", e)
+	}
+}
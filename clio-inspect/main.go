@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+
+	"github.com/FreeFeed/clio-restore/internal/archive"
+	"github.com/FreeFeed/clio-restore/internal/config"
+	"github.com/FreeFeed/clio-restore/internal/dbutil"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/davidmz/mustbe"
+	"github.com/juju/errors"
+	_ "github.com/lib/pq"
+)
+
+// Globals
+var (
+	infoLog  = logging.Info
+	fatalLog = logging.Fatal
+)
+
+func main() {
+	defer mustbe.Catched(func(err error) {
+		fatalLog.Println(err)
+		debug.PrintStack()
+		os.Exit(1)
+	})
+
+	var (
+		jsonOutput bool
+		writeVia   bool
+		top        int
+	)
+
+	flag.BoolVar(&jsonOutput, "json", false, "print statistics as JSON")
+	flag.BoolVar(&writeVia, "write-via", false, "write via statistics to via_sources of the owner's archive record")
+	flag.IntVar(&top, "top", 20, "number of the top comment and like authors to print")
+	flag.Parse()
+
+	mustbe.OK(logging.Setup("clio-inspect", os.Stderr))
+	defer logging.Close()
+
+	if flag.Arg(0) == "" {
+		fmt.Fprintln(os.Stderr, "Usage: clio-inspect [options] clio-archive")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	arch, err := archive.Open(flag.Arg(0))
+	mustbe.OK(errors.Annotate(err, "cannot open archive file"))
+	defer arch.Close()
+
+	stats := mustbe.OKVal(inspectArchive(arch)).(*archiveStats)
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		mustbe.OK(enc.Encode(stats))
+	} else {
+		stats.print(os.Stdout, top)
+	}
+
+	if writeVia {
+		conf := mustbe.OKVal(config.Load()).(*config.Config)
+		db := mustbe.OKVal(sql.Open("postgres", conf.DbStr)).(*sql.DB)
+		defer db.Close()
+		mustbe.OK(db.Ping())
+
+		res := mustbe.OKVal(db.Exec(
+			"update archives set via_sources = $1 where old_username = $2",
+			dbutil.JSONVal(stats.Via), stats.Owner,
+		)).(sql.Result)
+		if n := mustbe.OKVal(res.RowsAffected()).(int64); n == 0 {
+			mustbe.OK(errors.Errorf("cannot find archive record of %s", stats.Owner))
+		}
+		infoLog.Printf("via_sources of %s is updated (%d sources)", stats.Owner, len(stats.Via))
+	}
+}
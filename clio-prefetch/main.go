@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"sync"
+
+	"github.com/FreeFeed/clio-restore/internal/archive"
+	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/config"
+	"github.com/FreeFeed/clio-restore/internal/fetch"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/FreeFeed/clio-restore/internal/mediacache"
+	"github.com/FreeFeed/clio-restore/internal/thumbs"
+	"github.com/davidmz/mustbe"
+	"github.com/juju/errors"
+)
+
+// Globals
+var (
+	infoLog  = logging.Info
+	errorLog = logging.Error
+	fatalLog = logging.Fatal
+)
+
+var entryRe = regexp.MustCompile(`^[a-z0-9-]+/_json/data/entries/[0-9a-f]{8}\.js$`)
+
+func main() {
+	defer mustbe.Catched(func(err error) {
+		fatalLog.Println(err)
+		debug.PrintStack()
+	})
+
+	var workers int
+
+	flag.IntVar(&workers, "workers", 0, "number of entries processed in parallel (default is Workers from ini file or 1)")
+	flag.Parse()
+
+	mustbe.OK(logging.Setup("clio-prefetch", os.Stdout))
+	defer logging.Close()
+
+	if flag.Arg(0) == "" {
+		fmt.Fprintln(os.Stderr, "Usage: clio-prefetch [options] clio-archive")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	conf := mustbe.OKVal(config.Load()).(*config.Config)
+	if workers < 1 {
+		workers = conf.Workers
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if conf.MediaCache == "" {
+		mustbe.OK(errors.New("MediaCache is not defined in ini file"))
+	}
+
+	arch, err := archive.Open(flag.Arg(0))
+	mustbe.OK(errors.Annotate(err, "cannot open archive file"))
+	defer arch.Close()
+
+	p := &prefetcher{
+		Archive: arch,
+		Thumbs:  mustbe.OKVal(thumbs.Load(conf.ThumbRules)).(*thumbs.Registry),
+		Fetcher: &mediacache.Fetcher{
+			Cache:  mediacache.New(conf.MediaCache),
+			Client: fetch.New(conf.Fetch),
+		},
+	}
+	clio.FinalURL = p.Fetcher.FinalURL
+	clio.Unshorten = mustbe.OKVal(clio.NewUnshortener(&conf.Unshorten, false)).(*clio.Unshortener)
+
+	files := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				p.prefetchEntry(f)
+			}
+		}()
+	}
+	for _, name := range arch.Names {
+		if entryRe.MatchString(name) {
+			files <- name
+		}
+	}
+	close(files)
+	wg.Wait()
+
+	p.stats.print()
+	infoLog.Println("Done.")
+}
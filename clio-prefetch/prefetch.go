@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+	"sync"
+
+	"github.com/FreeFeed/clio-restore/internal/archive"
+	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/FreeFeed/clio-restore/internal/mediacache"
+	"github.com/FreeFeed/clio-restore/internal/thumbs"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+type prefetcher struct {
+	Archive *archive.Archive
+	Thumbs  *thumbs.Registry
+	Fetcher *mediacache.Fetcher
+
+	stats prefetchStats
+}
+
+type prefetchStats struct {
+	sync.Mutex
+	Entries   int
+	Images    int // images to restore
+	Local     int // local images (from archive)
+	Available int // remote images available in cache
+	Missing   int // remote images not available
+	Fetched   int // requests sent to network
+	Cached    int // requests that already was in the cache
+}
+
+func (s *prefetchStats) print() {
+	infoLog.Printf("Entries: %d", s.Entries)
+	infoLog.Printf("Images: %d (local: %d, remote available: %d, remote missing: %d)", s.Images, s.Local, s.Available, s.Missing)
+	infoLog.Printf("Requests: %d from network, %d from cache", s.Fetched, s.Cached)
+}
+
+func (s *prefetchStats) inc(counter *int) {
+	s.Lock()
+	*counter++
+	s.Unlock()
+}
+
+func (p *prefetcher) prefetchEntry(name string) {
+	entry := new(clio.Entry)
+	if err := p.Archive.ReadJSON(name, entry); err != nil {
+		errorLog.With("entry", name).Println("Cannot read entry:", err)
+		return
+	}
+	entry.InitText()
+	p.stats.inc(&p.stats.Entries)
+
+	res := p.Thumbs.Resolve(thumbs.FromEntry(entry))
+	for _, img := range res.Images {
+		p.stats.inc(&p.stats.Images)
+		if len(img.URLs) > 0 && thumbs.FFMediaURLRe.MatchString(img.URLs[0]) {
+			p.stats.inc(&p.stats.Local)
+			continue
+		}
+		ok := p.prefetchImage(img.URLs...)
+		if !ok && img.Lookup != nil && img.Lookup.Service == "flickr" {
+			ok = p.prefetchImage(p.getFlickrImageURLs(img.Lookup.PageURL)...)
+		}
+		if ok {
+			p.stats.inc(&p.stats.Available)
+		} else {
+			p.stats.inc(&p.stats.Missing)
+			errorLog.With("entry", entry.Name, "url", strings.Join(img.URLs, " ")).Println("Image is not available")
+		}
+	}
+}
+
+// prefetchImage fetches URLs in order until the first usable image, just as
+// clio-restore does
+func (p *prefetcher) prefetchImage(URLs ...string) bool {
+	for _, u := range URLs {
+		if thumbs.FFMediaURLRe.MatchString(u) {
+			// local image
+			return true
+		}
+		resp := p.get(u)
+		if resp != nil && isUsableImage(u, resp) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *prefetcher) getFlickrImageURLs(pageURL string) []string {
+	resp := p.get(thumbs.FlickrOEmbedURL(pageURL))
+	if resp == nil {
+		return nil
+	}
+	urls, err := thumbs.ParseFlickrOEmbed(resp.Body)
+	if err != nil {
+		errorLog.With("url", pageURL).Println("Cannot parse Flickr oEmbed page:", err)
+		return nil
+	}
+	return urls
+}
+
+func (p *prefetcher) get(u string) *mediacache.Response {
+	resp, err := p.Fetcher.Get(u)
+	if err != nil {
+		p.stats.inc(&p.stats.Fetched)
+		errorLog.With("url", u).Println("Cannot fetch URL:", err)
+		return nil
+	}
+	if resp.Cached {
+		p.stats.inc(&p.stats.Cached)
+	} else {
+		p.stats.inc(&p.stats.Fetched)
+		logging.Debug.With("url", u).Printf("Fetched: %d %s", resp.Status, resp.ContentType)
+	}
+	return resp
+}
+
+// isUsableImage checks the response as clio-restore does
+func isUsableImage(u string, resp *mediacache.Response) bool {
+	if thumbs.CheckImageResponse(u, resp.Status, resp.ContentType, resp.FinalURL) != nil {
+		return false
+	}
+	_, _, err := image.DecodeConfig(bytes.NewReader(resp.Body))
+	return err == nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/FreeFeed/clio-restore/internal/account"
+	"github.com/FreeFeed/clio-restore/internal/config"
+	"github.com/FreeFeed/clio-restore/internal/dbutil"
+	"github.com/davidmz/mustbe"
+	"github.com/lib/pq"
+)
+
+// pendingUsersSQL selects users who allow to restore comments and likes and
+// have the hidden ones
+const pendingUsersSQL = `select a.old_username from archives a
+	where a.restore_comments_and_likes and (
+		exists(select 1 from hidden_comments hc where hc.user_id = a.user_id or hc.old_username = a.old_username)
+		or exists(select 1 from hidden_likes hl where hl.user_id = a.user_id or hl.old_username = a.old_username)
+	)`
+
+// activitiesDaemon restores activities on DB notifications. The notification
+// payload is the old username of user whose archive settings are changed or
+// empty string if it is not known (all pending users are checked then). All
+// pending users are also checked on start, after the reconnection to DB and
+// every Poll interval.
+type activitiesDaemon struct {
+	DB      *sql.DB
+	Conf    *config.Config
+	Channel string
+	Poll    time.Duration
+
+	stop chan struct{} // closed on SIGINT or SIGTERM
+}
+
+// Run runs daemon until SIGINT or SIGTERM. The signal stops the daemon after
+// the current user is processed.
+func (d *activitiesDaemon) Run() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	d.stop = make(chan struct{})
+	go func() {
+		sig := <-sigs
+		infoLog.Printf("Got %s, exiting", sig)
+		close(d.stop)
+	}()
+
+	listener := pq.NewListener(d.Conf.DbStr, 10*time.Second, time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				errorLog.Println("Listener error:", err)
+			}
+		},
+	)
+	defer listener.Close()
+	mustbe.OK(listener.Listen(d.Channel))
+
+	infoLog.Printf("Listening to %q, poll interval is %s", d.Channel, d.Poll)
+
+	d.processPending()
+
+	ticker := time.NewTicker(d.Poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.processPending()
+		case n := <-listener.Notify:
+			if n == nil {
+				// Connection was re-established, notifications could be lost
+				d.processPending()
+				continue
+			}
+			names := map[string]bool{n.Extra: true}
+			// Collect other notifications that are already received
+		drain:
+			for {
+				select {
+				case n := <-listener.Notify:
+					if n == nil {
+						names[""] = true
+					} else {
+						names[n.Extra] = true
+					}
+				default:
+					break drain
+				}
+			}
+			if names[""] {
+				d.processPending()
+			} else {
+				d.processUsers(names)
+			}
+		}
+	}
+}
+
+// processPending restores activities of all pending users
+func (d *activitiesDaemon) processPending() {
+	names := make(map[string]bool)
+	err := dbutil.QueryRows(d.DB, pendingUsersSQL, nil, func(r dbutil.RowScanner) error {
+		var name string
+		if err := r.Scan(&name); err != nil {
+			return err
+		}
+		names[name] = true
+		return nil
+	})
+	if err != nil {
+		errorLog.Println("Cannot fetch pending users:", err)
+		return
+	}
+	lastCheckMetric.Set(timestamp())
+	d.processUsers(names)
+}
+
+// processUsers restores activities of the given users if they allow it
+func (d *activitiesDaemon) processUsers(names map[string]bool) {
+	accStore := account.NewStore(d.DB)
+	for name := range names {
+		if d.stopped() {
+			return
+		}
+		d.processUser(accStore, name)
+	}
+}
+
+func (d *activitiesDaemon) stopped() bool {
+	select {
+	case <-d.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *activitiesDaemon) processUser(accStore *account.Store, name string) {
+	defer mustbe.Catched(func(err error) {
+		errorLog.Printf("Cannot restore activities of %q: %v", name, err)
+		usersMetric.Inc("error")
+	})
+
+	acc := accStore.Get(name)
+	if !acc.RestoreCommentsAndLikes {
+		return
+	}
+	restoreActivities(d.DB, d.Conf, acc)
+}
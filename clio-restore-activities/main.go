@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"runtime/debug"
 	"time"
@@ -13,6 +12,8 @@ import (
 	"github.com/FreeFeed/clio-restore/internal/config"
 	"github.com/FreeFeed/clio-restore/internal/dbutil"
 	"github.com/FreeFeed/clio-restore/internal/hashtags"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/FreeFeed/clio-restore/internal/metrics"
 	"github.com/davidmz/mustbe"
 	"github.com/lib/pq"
 	"gopkg.in/gomail.v2"
@@ -20,9 +21,9 @@ import (
 
 // Globals
 var (
-	infoLog  = log.New(os.Stdout, "INFO  ", log.LstdFlags)
-	errorLog = log.New(os.Stdout, "ERROR ", log.LstdFlags)
-	fatalLog = log.New(os.Stdout, "FATAL ", log.LstdFlags)
+	infoLog  = logging.Info
+	errorLog = logging.Error
+	fatalLog = logging.Fatal
 )
 
 func main() {
@@ -31,13 +32,38 @@ func main() {
 		debug.PrintStack()
 	})
 
+	var (
+		daemon  bool
+		channel string
+		poll    time.Duration
+	)
+
+	flag.BoolVar(&daemon, "daemon", false, "run continuously, restore activities on notifications from DB")
+	flag.StringVar(&channel, "channel", "clio_activities", "notification channel to listen in daemon mode")
+	flag.DurationVar(&poll, "poll", 10*time.Minute, "interval of the pending users check in daemon mode")
 	flag.Parse()
 
+	if daemon && poll <= 0 {
+		fmt.Fprintln(os.Stderr, "-poll must be positive")
+		os.Exit(1)
+	}
+
+	mustbe.OK(logging.Setup("clio-restore-activities", os.Stdout))
+	defer logging.Close()
+	mustbe.OK(metrics.Start())
+	defer metrics.Stop()
+
 	conf := mustbe.OKVal(config.Load()).(*config.Config)
 
 	db := mustbe.OKVal(sql.Open("postgres", conf.DbStr)).(*sql.DB)
 	mustbe.OK(db.Ping())
 
+	if daemon {
+		d := &activitiesDaemon{DB: db, Conf: conf, Channel: channel, Poll: poll}
+		d.Run()
+		return
+	}
+
 	accStore := account.NewStore(db)
 
 	// Looking for users who allow to restore their comments and likes
@@ -55,64 +81,84 @@ func main() {
 	))
 
 	infoLog.Printf("Found %d users who allow to restore comments and likes", len(accounts))
+	lastCheckMetric.Set(timestamp())
 
 	for _, acc := range accounts {
-		infoLog.Printf("Processing %q (now %q)", acc.OldUserName, acc.NewUserName)
+		restoreActivities(db, conf, acc)
+	}
+}
 
-		if !acc.IsExists() {
-			errorLog.Printf("Looks like account with old username %q doesn't exists", acc.OldUserName)
-			continue
-		}
+// restoreActivities restores hidden comments and likes of acc if there are any
+func restoreActivities(db *sql.DB, conf *config.Config, acc *account.Account) {
+	logging.SetContext("owner", acc.OldUserName, "newOwner", acc.NewUserName)
+	defer logging.SetContext()
 
-		var existsComments, existsLikes bool
+	infoLog.Printf("Processing %q (now %q)", acc.OldUserName, acc.NewUserName)
 
-		mustbe.OK(db.QueryRow(
-			`select exists(select 1 from hidden_comments where user_id = $1 or old_username = $2)`,
-			acc.UID, acc.OldUserName,
-		).Scan(&existsComments))
+	if !acc.IsExists() {
+		errorLog.Printf("Looks like account with old username %q doesn't exists", acc.OldUserName)
+		usersMetric.Inc("missing")
+		return
+	}
 
-		mustbe.OK(db.QueryRow(
-			`select exists(select 1 from hidden_likes where user_id = $1 or old_username = $2)`,
-			acc.UID, acc.OldUserName,
-		).Scan(&existsLikes))
+	var existsComments, existsLikes bool
 
-		if !existsComments && !existsLikes {
-			continue
-		}
+	mustbe.OK(db.QueryRow(
+		`select exists(select 1 from hidden_comments where user_id = $1 or old_username = $2)`,
+		acc.UID, acc.OldUserName,
+	).Scan(&existsComments))
 
-		dbutil.MustTransact(db, func(tx *sql.Tx) {
-			if existsComments {
-				infoLog.Printf("Restoring hidden comments of %q (now %q)", acc.OldUserName, acc.NewUserName)
-				restoreComments(tx, acc)
-			}
-			if existsLikes {
-				infoLog.Printf("Restoring hidden likes of %q (now %q)", acc.OldUserName, acc.NewUserName)
-				restoreLikes(tx, acc)
-			}
-		})
-
-		if conf.SMTPHost != "" {
-			dialer := gomail.NewDialer(conf.SMTPHost, conf.SMTPPort, conf.SMTPUsername, conf.SMTPPassword)
-			mail := gomail.NewMessage()
-			mail.SetHeader("From", conf.SMTPFrom)
-			mail.SetHeader("To", acc.Email, conf.SMTPBcc)
-			mail.SetHeader("Subject", "Archive comments restoration request")
-			mail.SetBody("text/plain",
-				fmt.Sprintf(
-					"Comments restoration for FreeFeed user %q (FriendFeed username %q) has been completed.",
-					acc.NewUserName, acc.OldUserName,
-				),
-			)
-			if err := dialer.DialAndSend(mail); err != nil {
-				errorLog.Printf("Cannot send email to %q: %v", acc.Email, err)
-			}
+	mustbe.OK(db.QueryRow(
+		`select exists(select 1 from hidden_likes where user_id = $1 or old_username = $2)`,
+		acc.UID, acc.OldUserName,
+	).Scan(&existsLikes))
+
+	if !existsComments && !existsLikes {
+		usersMetric.Inc("nothing")
+		return
+	}
+
+	var comments, likes int
+	txStarted := time.Now()
+	dbutil.MustTransact(db, func(tx *sql.Tx) {
+		if existsComments {
+			infoLog.Printf("Restoring hidden comments of %q (now %q)", acc.OldUserName, acc.NewUserName)
+			comments = restoreComments(db, tx, acc)
+		}
+		if existsLikes {
+			infoLog.Printf("Restoring hidden likes of %q (now %q)", acc.OldUserName, acc.NewUserName)
+			likes = restoreLikes(tx, acc)
+		}
+	})
+	txDurationMetric.Observe(time.Since(txStarted).Seconds())
+	commentsMetric.Add(float64(comments))
+	likesMetric.Add(float64(likes))
+	usersMetric.Inc("restored")
+
+	if conf.SMTPHost != "" {
+		dialer := gomail.NewDialer(conf.SMTPHost, conf.SMTPPort, conf.SMTPUsername, conf.SMTPPassword)
+		mail := gomail.NewMessage()
+		mail.SetHeader("From", conf.SMTPFrom)
+		mail.SetHeader("To", acc.Email, conf.SMTPBcc)
+		mail.SetHeader("Subject", "Archive comments restoration request")
+		mail.SetBody("text/plain",
+			fmt.Sprintf(
+				"Comments restoration for FreeFeed user %q (FriendFeed username %q) has been completed.",
+				acc.NewUserName, acc.OldUserName,
+			),
+		)
+		if err := dialer.DialAndSend(mail); err != nil {
+			errorLog.Printf("Cannot send email to %q: %v", acc.Email, err)
 		}
 	}
 }
 
 const batchSize = 100
 
-func restoreComments(tx *sql.Tx, acc *account.Account) {
+// restoreComments restores comments in tx. Hashtags are created outside of tx
+// (in db): the hashtag IDs are cached for the whole process and must not be
+// lost on rollback.
+func restoreComments(db *sql.DB, tx *sql.Tx, acc *account.Account) int {
 	var (
 		feeds pq.Int64Array
 		count int
@@ -156,7 +202,7 @@ func restoreComments(tx *sql.Tx, acc *account.Account) {
 
 			for _, h := range hashtags.Extract(ci.Body) {
 				dbutil.MustInsertWithoutConflict(tx, "hashtag_usages", dbutil.H{
-					"hashtag_id": hashtags.GetID(tx, h),
+					"hashtag_id": hashtags.GetID(db, h),
 					"entity_id":  ci.ID,
 					"type":       "comment",
 				})
@@ -179,9 +225,10 @@ func restoreComments(tx *sql.Tx, acc *account.Account) {
 	))
 
 	infoLog.Printf("Restored %d comments in %d posts", count, len(processedPosts))
+	return count
 }
 
-func restoreLikes(tx *sql.Tx, acc *account.Account) {
+func restoreLikes(tx *sql.Tx, acc *account.Account) int {
 	var (
 		feeds pq.Int64Array
 		count int
@@ -239,4 +286,5 @@ func restoreLikes(tx *sql.Tx, acc *account.Account) {
 	))
 
 	infoLog.Printf("Restored %d likes", count)
+	return count
 }
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"time"
+
+	"github.com/FreeFeed/clio-restore/internal/metrics"
+)
+
+// Activities restoration metrics
+var (
+	usersMetric = metrics.Default.Counter(
+		"clio_activities_users_total", "Processed users by result (restored, nothing, missing or error).", "result")
+	commentsMetric = metrics.Default.Counter(
+		"clio_activities_comments_total", "Restored hidden comments.")
+	likesMetric = metrics.Default.Counter(
+		"clio_activities_likes_total", "Restored hidden likes.")
+	txDurationMetric = metrics.Default.Histogram(
+		"clio_activities_transaction_duration_seconds", "Duration of the user transactions.", metrics.DefBuckets)
+	lastCheckMetric = metrics.Default.Gauge(
+		"clio_activities_last_check_timestamp_seconds", "Time of the last check of the pending users.")
+)
+
+func timestamp() float64 { return float64(time.Now().UnixNano()) / 1e9 }
@@ -1,21 +1,29 @@
 package main
 
 import (
-	"archive/zip"
 	"bufio"
 	"database/sql"
 	"fmt"
-	"io"
+	"io/fs"
 	"path"
 	"regexp"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/FreeFeed/clio-restore/internal/account"
+	"github.com/FreeFeed/clio-restore/internal/archive"
 	"github.com/FreeFeed/clio-restore/internal/clio"
 	"github.com/FreeFeed/clio-restore/internal/config"
 	"github.com/FreeFeed/clio-restore/internal/dbutil"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/FreeFeed/clio-restore/internal/fetch"
+	"github.com/FreeFeed/clio-restore/internal/hashtags"
+	"github.com/FreeFeed/clio-restore/internal/imgproc"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/FreeFeed/clio-restore/internal/mediacache"
+	"github.com/FreeFeed/clio-restore/internal/scrub"
+	"github.com/FreeFeed/clio-restore/internal/storage"
+	"github.com/FreeFeed/clio-restore/internal/thumbs"
 	"github.com/davidmz/mustbe"
 	"github.com/juju/errors"
 	"github.com/lib/pq"
@@ -26,49 +34,89 @@ import (
 type App struct {
 	*config.Config
 	DB       *sql.DB
-	Tx       *sql.Tx
-	S3Client *s3.S3
+	Storage  storage.Storage
 	Accounts *account.Store
-	Owner    *account.Account
-	ZipFiles zipFilesList
-	// Mp3Files       map[string]*zip.File  // map ID -> *zip.File
-	ImageFiles     map[string]*localFile // map ID -> *zip.File
-	OtherFiles     map[string]*localFile // map ID -> *zip.File
+	Thumbs   *thumbs.Registry
+	Fetcher  *mediacache.Fetcher
+	Dedup    *dedupIndex
+	Schema   schemaFeatures
+
+	ImageProc      imgproc.Processor
+	Metadata       scrub.Policy
+	Owner          *account.Account
+	Archive        *archive.Archive
+	ImageFiles     map[string]*localFile // map ID -> archived file
+	OtherFiles     map[string]*localFile // map ID -> archived file
 	ViaToRestore   map[string]bool       // via sources (URLs) to restore
 	PostsToRestore int
-	AttOrd int
-
-	mp3ZipReader *zip.ReadCloser
+	FromDate       time.Time // restore entries created after this date
+	ToDate         time.Time // restore entries created before this date
+	IgnoreSources  bool      // restore all entries regardless of ViaToRestore
+	DryRun         bool      // do not write anything, just print the restoration plan
+	Offline        bool      // do not use network, take remote media only from the media cache
+	ReportFile     string    // file to write the run report to
+
+	mp3Archive *archive.Archive
+	vias       *viaCache
+	imageSem   chan struct{} // limits the number of parallel image processing commands
+	planTotals planTotals
+	report     runReport
 }
 
 // Init initialises App by Config
-func (a *App) Init(zipFiles []*zip.File, conf *config.Config) {
+func (a *App) Init(arch *archive.Archive, conf *config.Config) {
 	a.Config = conf
 
-	a.ZipFiles = zipFiles
+	// Caches are per run, in spool mode the previous archive (even failed)
+	// must not affect the next one
+	a.vias = newViaCache()
+	hashtags.ResetCache()
+
+	a.Archive = arch
 
 	a.readImageFiles()
 	a.readOtherFiles()
 
-	if a.MP3Zip != "" { // Open MP3 zip
+	a.Thumbs = mustbe.OKVal(thumbs.Load(a.ThumbRules)).(*thumbs.Registry)
+
+	a.Fetcher = &mediacache.Fetcher{Client: fetch.New(a.Fetch), Offline: a.Offline}
+	if a.MediaCache != "" {
+		a.Fetcher.Cache = mediacache.New(a.MediaCache)
+	} else if a.Offline {
+		mustbe.OK(errors.New("offline mode requires MediaCache"))
+	}
+	clio.FinalURL = a.Fetcher.FinalURL
+	if a.DryRun {
+		// Short links are not requested, the plan lists them instead
+		clio.FinalURL = func(string) (string, error) { return "", errDryRun }
+	}
+	clio.Unshorten = mustbe.OKVal(clio.NewUnshortener(&a.Unshorten, a.DryRun)).(*clio.Unshortener)
+
+	imageWorkers := a.ImageWorkers
+	if imageWorkers < 1 {
+		imageWorkers = runtime.NumCPU()
+	}
+	a.imageSem = make(chan struct{}, imageWorkers)
+	a.ImageProc = mustbe.OKVal(imgproc.New(a.ImageProcessor, a.GM, a.GifSicle, a.SRGB)).(imgproc.Processor)
+	a.Metadata = mustbe.OKVal(scrub.ParsePolicy(a.ImageMetadata)).(scrub.Policy)
+
+	if a.MP3Zip != "" { // Open MP3 archive
 		var err error
-		a.mp3ZipReader, err = zip.OpenReader(conf.MP3Zip)
+		a.mp3Archive, err = archive.Open(conf.MP3Zip)
 		mustbe.OK(errors.Annotate(err, "cannot open MP3 archive file"))
 		mp3Re := regexp.MustCompile(`([0-9a-f]+)\.mp3$`)
-		for _, f := range a.mp3ZipReader.File {
-			m := mp3Re.FindStringSubmatch(f.Name)
+		for _, name := range a.mp3Archive.Names {
+			m := mp3Re.FindStringSubmatch(name)
 			if m != nil {
 				if _, ok := a.OtherFiles[m[1]]; !ok {
-					a.OtherFiles[m[1]] = &localFile{File: f, OrigName: path.Base(f.Name)}
+					a.OtherFiles[m[1]] = &localFile{Archive: a.mp3Archive, Name: name, OrigName: path.Base(name)}
 				}
 			}
 		}
 	}
 
-	if a.AttDir == "" { // use S3
-		awsSession, err := session.NewSession()
-		mustbe.OK(errors.Annotate(err, "cannot create AWS session"))
-		a.S3Client = s3.New(awsSession)
+	if !a.DryRun {
+		a.Storage = mustbe.OKVal(storage.New(a.Config)).(storage.Storage)
 	}
 
 	{ // Connect to DB
@@ -79,6 +127,13 @@ func (a *App) Init(zipFiles []*zip.File, conf *config.Config) {
 	}
 
 	a.Accounts = account.NewStore(a.DB)
+	a.Schema = readSchemaFeatures(a.DB)
+	if !a.Schema.Video {
+		infoLog.Println("DB schema doesn't support video attachments, videos are restored as general files")
+	}
+	if !a.Schema.Duration {
+		infoLog.Println("DB schema has no attachments.duration column, audio duration is not restored")
+	}
 
 	oldUserName, err := a.getArchiveOwnerName()
 	mustbe.OK(errors.Annotate(err, "cannot get archive owner"))
@@ -91,6 +146,12 @@ func (a *App) Init(zipFiles []*zip.File, conf *config.Config) {
 	}
 
 	infoLog.Printf("%s new username is %s", a.Owner.OldUserName, a.Owner.NewUserName)
+	logging.SetContext("owner", a.Owner.OldUserName, "newOwner", a.Owner.NewUserName)
+
+	a.Dedup = newDedupIndex()
+	if a.DedupExisting && !a.DryRun {
+		a.loadExistingImages(a.Owner.UID)
+	}
 
 	{
 		var recStatus int
@@ -138,16 +199,19 @@ func (a *App) Init(zipFiles []*zip.File, conf *config.Config) {
 
 // Close closes opened resources
 func (a *App) Close() {
-	if a.mp3ZipReader != nil {
-		a.mp3ZipReader.Close()
+	if a.mp3Archive != nil {
+		a.mp3Archive.Close()
+	}
+	if a.DB != nil {
+		a.DB.Close()
 	}
 }
 
 func (a *App) getArchiveOwnerName() (string, error) {
 	// Looking for feedinfo.js in files
-	if f, ok := a.ZipFiles.FindByRe(feedInfoRe); ok {
+	if name, ok := a.Archive.Find(feedInfoRe); ok {
 		user := new(clio.UserJSON)
-		if err := readZipObject(f, user); err != nil {
+		if err := a.Archive.ReadJSON(name, user); err != nil {
 			return "", err
 		}
 		if user.Type != "user" {
@@ -195,8 +259,8 @@ func (a *App) readImageFiles() {
 	)
 
 	// Looking for the TSV file
-	if f, ok := a.ZipFiles.FindByRe(tsvFileRe); ok {
-		r := mustbe.OKVal(f.Open()).(io.ReadCloser)
+	if name, ok := a.Archive.Find(tsvFileRe); ok {
+		r := mustbe.OKVal(a.Archive.Open(name)).(fs.File)
 		scanner := bufio.NewScanner(r)
 		for scanner.Scan() {
 			parts := strings.SplitN(scanner.Text(), "\t", 2)
@@ -213,16 +277,16 @@ func (a *App) readImageFiles() {
 	}
 
 	// Now looking for images
-	for _, f := range a.ZipFiles {
-		if imageFileRe.MatchString(f.Name) {
-			name := imageFileRe.FindStringSubmatch(f.Name)[1]
+	for _, f := range a.Archive.Names {
+		if imageFileRe.MatchString(f) {
+			name := imageFileRe.FindStringSubmatch(f)[1]
 			if id, ok := name2id[name]; ok {
-				a.ImageFiles[id] = &localFile{File: f, OrigName: name}
+				a.ImageFiles[id] = &localFile{Archive: a.Archive, Name: f, OrigName: name}
 			}
 		}
-		if thumbFileRe.MatchString(f.Name) {
-			m := thumbFileRe.FindStringSubmatch(f.Name)
-			a.ImageFiles[m[2]] = &localFile{File: f, OrigName: m[1]}
+		if thumbFileRe.MatchString(f) {
+			m := thumbFileRe.FindStringSubmatch(f)
+			a.ImageFiles[m[2]] = &localFile{Archive: a.Archive, Name: f, OrigName: m[1]}
 		}
 	}
 
@@ -240,8 +304,8 @@ func (a *App) readOtherFiles() {
 	)
 
 	// Looking for the TSV file
-	if f, ok := a.ZipFiles.FindByRe(tsvFileRe); ok {
-		r := mustbe.OKVal(f.Open()).(io.ReadCloser)
+	if name, ok := a.Archive.Find(tsvFileRe); ok {
+		r := mustbe.OKVal(a.Archive.Open(name)).(fs.File)
 		scanner := bufio.NewScanner(r)
 		for scanner.Scan() {
 			parts := strings.SplitN(scanner.Text(), "\t", 2)
@@ -258,11 +322,11 @@ func (a *App) readOtherFiles() {
 	}
 
 	// Now looking for files
-	for _, f := range a.ZipFiles {
-		if otherFileRe.MatchString(f.Name) {
-			name := otherFileRe.FindStringSubmatch(f.Name)[1]
+	for _, f := range a.Archive.Names {
+		if otherFileRe.MatchString(f) {
+			name := otherFileRe.FindStringSubmatch(f)[1]
 			if id, ok := name2id[name]; ok {
-				a.OtherFiles[id] = &localFile{File: f, OrigName: name}
+				a.OtherFiles[id] = &localFile{Archive: a.Archive, Name: f, OrigName: name}
 			}
 		}
 	}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/dbutil"
+)
+
+// attachment is a prepared attachment: all its files (original and thumbnails)
+// are loaded and processed and its DB row is filled except of the post-related
+// fields. Preparation is done before the entry transaction starts, so the
+// transaction itself contains only the DB inserts.
+type attachment struct {
+	UID         string
+	Name        string
+	ContentType string
+	Files       []attachmentFile
+	Row         dbutil.H     // attachments row without post_id, user_id, ord and dates
+	image       *storedImage // image info for deduplication (nil if not a new image)
+}
+
+type attachmentFile struct {
+	Path        string // path in storage
+	Body        []byte
+	CopyFrom    string // if not empty, file is a copy of this stored file
+	ContentType string // if not empty, overrides the attachment ContentType
+}
+
+// storeAttachmentFiles uploads all attachment files to the storage
+func (j *restoreJob) storeAttachmentFiles(att *attachment) {
+	for _, f := range att.Files {
+		contentType := att.ContentType
+		if f.ContentType != "" {
+			contentType = f.ContentType
+		}
+		if f.CopyFrom != "" {
+			j.copyAttachment(f.CopyFrom, f.Path, att.Name, contentType)
+		} else {
+			j.storeAttachment(f.Body, f.Path, att.Name, contentType)
+		}
+	}
+}
+
+// insertAttachment writes attachment row to the DB in the current transaction
+func (j *restoreJob) insertAttachment(att *attachment, postUID string, entry *clio.Entry) {
+	row := dbutil.H{
+		"post_id":    postUID,
+		"user_id":    entry.Author.UID,
+		"ord":        j.attOrd,
+		"created_at": entry.Date,
+		"updated_at": entry.Date,
+	}
+	for k, v := range att.Row {
+		row[k] = v
+	}
+	dbutil.MustInsert(j.Tx, "attachments", row)
+	j.attOrd++
+}
@@ -1,6 +1,10 @@
 package main
 
-import "regexp"
+import (
+	"regexp"
+
+	"github.com/FreeFeed/clio-restore/internal/thumbs"
+)
 
 const (
 	commentTypeVisible = 0
@@ -23,14 +27,6 @@ const (
 var (
 	feedInfoRe   = regexp.MustCompile(`^[a-z0-9-]+/_json/data/feedinfo\.js$`)
 	entryRe      = regexp.MustCompile(`^[a-z0-9-]+/_json/data/entries/[0-9a-f]{8}\.js$`)
-	ffMediaURLRe = regexp.MustCompile(`http://(?:(?:m\.)?friendfeed-media\.com|i\.friendfeed\.com)/([0-9a-f]+)`)
-
-	imgurRe          = regexp.MustCompile(`http://(?:i\.)?imgur\.com/(\w+?)s\.jpg`)
-	picasaImageRe    = regexp.MustCompile(`http://lh\d+\.ggpht\.com/`)
-	instagramImageRe = regexp.MustCompile(`http://[^/]+\.instagram\.com/`)
-	soupImageRe      = regexp.MustCompile(`http://asset-\w\.soup\.io\/asset\/`)
-	flickrImageRe    = regexp.MustCompile(`https?://[^/]+\.static\.?flickr\.com/.+_[a-z]\.jpg`)
-
-	fileIDRe      = regexp.MustCompile(`[0-9a-f]+$`)
-	instagramIDRe = regexp.MustCompile(`http://(?:instagr\.am|instagram.com)/p/([^/]+)`)
+	ffMediaURLRe = thumbs.FFMediaURLRe
+	fileIDRe     = regexp.MustCompile(`[0-9a-f]+$`)
 )
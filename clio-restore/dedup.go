@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/FreeFeed/clio-restore/internal/config"
+	"github.com/FreeFeed/clio-restore/internal/dbutil"
+	"github.com/davidmz/mustbe"
+	"github.com/gofrs/uuid"
+)
+
+// storedImage is a stored image attachment that can be reused by the
+// attachments with the same content
+type storedImage struct {
+	UID      string
+	Ext      string
+	MIMEType string
+	FileSize int
+	Sizes    imageSizes // without bodies
+	Bytes    int        // total size of all image files
+	Hash     string     // SHA-256 of the source image, empty for the existing images
+	OrigHash string     // SHA-256 of the stored original ("o") image
+}
+
+// dedupIndex is an index of the stored images by their SHA-256. Images are
+// added to index after the entry transaction commits, so the indexed files
+// are never deleted by the failed entry cleanup.
+//
+// The new images are found by the source image hash. The source images of the
+// existing user's images are unknown, so they are found by the hash of the
+// processed original image.
+type dedupIndex struct {
+	mu     sync.Mutex
+	byHash map[string]*storedImage // by the source image hash
+	byOrig map[string]*storedImage // by the stored original image hash
+	bySize map[int]*sizeGroup      // existing user's images by the original image size
+
+	Images int // number of reused images
+	Files  int // number of reused files
+	Bytes  int // total size of reused files, they are copied instead of upload
+}
+
+// sizeGroup is a group of the existing images of the same size. Images are
+// hashed lazily, when the new image of the same size appears.
+type sizeGroup struct {
+	once   sync.Once
+	images []*storedImage
+}
+
+func newDedupIndex() *dedupIndex {
+	return &dedupIndex{
+		byHash: make(map[string]*storedImage),
+		byOrig: make(map[string]*storedImage),
+		bySize: make(map[int]*sizeGroup),
+	}
+}
+
+func hashOf(body []byte) string {
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:])
+}
+
+// add adds stored images of attachments to index
+func (d *dedupIndex) add(atts []*attachment) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, att := range atts {
+		img := att.image
+		if img == nil {
+			continue
+		}
+		if img.Hash != "" && d.byHash[img.Hash] == nil {
+			d.byHash[img.Hash] = img
+		}
+		if img.OrigHash != "" && d.byOrig[img.OrigHash] == nil {
+			d.byOrig[img.OrigHash] = img
+		}
+	}
+}
+
+// loadExistingImages adds to index the existing image attachments of the user
+func (a *App) loadExistingImages(userID string) {
+	count := 0
+	dbutil.MustQueryRows(a.DB,
+		`select uid, file_size, mime_type, file_extension, image_sizes from attachments
+		where user_id = $1 and media_type = 'image'
+			and file_size is not null and image_sizes is not null`,
+		dbutil.Args{userID},
+		func(r dbutil.RowScanner) {
+			img := &storedImage{Sizes: make(imageSizes)}
+			mustbe.OK(r.Scan(&img.UID, &img.FileSize, &img.MIMEType, &img.Ext, dbutil.JSONVal(&img.Sizes)))
+			if _, ok := img.Sizes[config.OriginalSize]; !ok {
+				return
+			}
+			// Files may be stored with other presets, so the paths are taken
+			// from URLs
+			for szID, sz := range img.Sizes {
+				key, ok := a.storageKey(sz.URL)
+				if !ok || path.Base(key) != img.UID+"."+img.Ext {
+					return
+				}
+				sz.DirName = path.Dir(key)
+				for altFmt, altURL := range sz.Alt {
+					if key, ok := a.storageKey(altURL); !ok || key != sz.path(img.UID, altFmt) {
+						return
+					}
+				}
+				img.Sizes[szID] = sz
+			}
+			img.Bytes = img.FileSize // sizes of the thumbnails are unknown
+			g := a.Dedup.bySize[img.FileSize]
+			if g == nil {
+				g = new(sizeGroup)
+				a.Dedup.bySize[img.FileSize] = g
+			}
+			g.images = append(g.images, img)
+			count++
+		},
+	)
+	infoLog.Printf("%d existing images loaded for deduplication", count)
+}
+
+// storageKey returns the storage key of the attachment file URL
+func (a *App) storageKey(fileURL string) (string, bool) {
+	prefix := a.AttURL + "/"
+	if !strings.HasPrefix(fileURL, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(fileURL, prefix), true
+}
+
+// findBySource returns the stored image with the given source image hash or
+// nil if not found
+func (j *restoreJob) findBySource(hash string) *storedImage {
+	j.Dedup.mu.Lock()
+	defer j.Dedup.mu.Unlock()
+	return j.Dedup.byHash[hash]
+}
+
+// findByOriginal returns the stored image with the given original image hash
+// and size or nil if not found
+func (j *restoreJob) findByOriginal(hash string, size int) *storedImage {
+	d := j.Dedup
+	d.mu.Lock()
+	g := d.bySize[size]
+	d.mu.Unlock()
+
+	if g != nil {
+		// Other jobs looking for the same size wait until images are hashed
+		g.once.Do(func() { j.hashExistingImages(g.images) })
+	}
+
+	d.mu.Lock()
+	img := d.byOrig[hash]
+	d.mu.Unlock()
+	if img != nil && img.Hash == "" && !j.filesExist(img) {
+		return nil // the existing image is damaged, make the new one
+	}
+	return img
+}
+
+// filesExist returns true if all files of the stored image exist
+func (j *restoreJob) filesExist(img *storedImage) bool {
+	for _, sz := range img.Sizes {
+		keys := []string{sz.path(img.UID, img.Ext)}
+		for altFmt := range sz.Alt {
+			keys = append(keys, sz.path(img.UID, altFmt))
+		}
+		for _, key := range keys {
+			if ok, err := j.Storage.Exists(key); err != nil || !ok {
+				j.errorLog.Printf("Cannot reuse existing image %s: %s is not available (%v)", img.UID, key, err)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hashExistingImages reads the stored original images and adds them to index
+func (j *restoreJob) hashExistingImages(images []*storedImage) {
+	for _, img := range images {
+		body, err := j.Storage.Read(img.Sizes[config.OriginalSize].path(img.UID, img.Ext))
+		if err != nil {
+			j.errorLog.Printf("Cannot read existing image: %v", err)
+			continue
+		}
+		img.OrigHash = hashOf(body)
+		j.Dedup.mu.Lock()
+		if j.Dedup.byOrig[img.OrigHash] == nil {
+			j.Dedup.byOrig[img.OrigHash] = img
+		}
+		j.Dedup.mu.Unlock()
+	}
+}
+
+// reuseImage creates attachment that reuses files of the stored image src
+func (j *restoreJob) reuseImage(src *storedImage, name string) *attachment {
+
+	uid := mustbe.OKVal(uuid.NewV4()).(uuid.UUID).String()
+	iSizes := make(imageSizes)
+	for szID, sz := range src.Sizes {
+		iSizes[szID] = sz
+	}
+	iSizes.setName(j.AttURL, uid, src.Ext)
+
+	att := &attachment{
+		UID:         uid,
+		Name:        name,
+		ContentType: src.MIMEType,
+		Row: dbutil.H{
+			"uid":            uid,
+			"file_name":      name,
+			"file_size":      src.FileSize,
+			"mime_type":      src.MIMEType,
+			"media_type":     "image",
+			"file_extension": src.Ext,
+			"no_thumbnail":   len(iSizes) == 1,
+			"image_sizes":    dbutil.JSONVal(iSizes),
+		},
+	}
+	for _, sz := range iSizes {
+		att.Files = append(att.Files, attachmentFile{
+			Path:     sz.path(uid, src.Ext),
+			CopyFrom: sz.path(src.UID, src.Ext),
+		})
+		for altFmt := range sz.Alt {
+			att.Files = append(att.Files, attachmentFile{
+				Path:        sz.path(uid, altFmt),
+				CopyFrom:    sz.path(src.UID, altFmt),
+				ContentType: config.AltFormats[altFmt],
+			})
+		}
+	}
+
+	j.Dedup.mu.Lock()
+	j.Dedup.Images++
+	j.Dedup.Files += len(att.Files)
+	j.Dedup.Bytes += src.Bytes
+	j.Dedup.mu.Unlock()
+
+	j.infoLog.Printf("Image is the same as the stored image %s", src.UID)
+	return att
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/thumbs"
+	"github.com/juju/errors"
+)
+
+// In dry-run mode the restoration pipeline runs as usual but it doesn't write
+// anything to the DB or to the attachments storage. Instead, it collects the
+// plan of each entry and prints it. The network lookups (Flickr oEmbed pages,
+// short links) are not performed too, the plan lists them as "would look up".
+
+var errDryRun = errors.New("no network requests in dry-run mode")
+
+type entryPlan struct {
+	Entry       *clio.Entry
+	Attachments []string
+	ShortLinks  []string
+	Comments    []activityPlan
+	Likes       []activityPlan
+}
+
+type activityPlan struct {
+	Author  string
+	Visible bool
+}
+
+type planTotals struct {
+	Posts           int
+	LocalImages     int
+	RemoteImages    int
+	MissingImages   int
+	Files           int
+	MissingFiles    int
+	ShortLinks      int
+	VisibleComments int
+	HiddenComments  int
+	VisibleLikes    int
+	HiddenLikes     int
+}
+
+func (j *restoreJob) planEntry(entry *clio.Entry) {
+	j.plan = &entryPlan{Entry: entry}
+
+	j.restoreThumbnails(entry)
+	j.restoreFiles(entry)
+	j.planShortLinks(entry)
+
+	for _, c := range entry.Comments {
+		j.commentPost("", entry.Author, c)
+	}
+	for _, l := range entry.Likes {
+		j.likePost("", l)
+	}
+
+	j.planTotals.Posts++
+	j.plan.print(&j.out)
+}
+
+// planImageAttachment is a dry-run version of createImageAttachment
+func (j *restoreJob) planImageAttachment(URLs ...string) (att *attachment, ok bool) {
+	var remoteURLs []string
+	for _, u := range URLs {
+		if ffMediaURLRe.MatchString(u) {
+			id := ffMediaURLRe.FindStringSubmatch(u)[1]
+			if lf, exists := j.ImageFiles[id]; exists {
+				j.plan.addAttachment("Image from local file %s (%s)", lf.Name, u)
+				j.planTotals.LocalImages++
+				return nil, true
+			}
+			j.plan.addAttachment("Image not found: %s (no local file)", u)
+			j.planTotals.MissingImages++
+			continue
+		}
+		remoteURLs = append(remoteURLs, u)
+	}
+
+	if len(remoteURLs) == 0 {
+		return nil, false
+	}
+
+	j.plan.addAttachment("Image, would fetch: %s", strings.Join(remoteURLs, ", "))
+	j.planTotals.RemoteImages++
+	return nil, true
+}
+
+// planFlickrLookup is a dry-run version of the Flickr image lookup. If the
+// image is planned to be fetched, the lookup is planned for the case the fetch
+// fails.
+func (j *restoreJob) planFlickrLookup(pageURL string, planned bool) {
+	if planned {
+		j.plan.addAttachment("  if not available, would look up: %s", thumbs.FlickrOEmbedURL(pageURL))
+		return
+	}
+	j.plan.addAttachment("Image, would look up: %s", thumbs.FlickrOEmbedURL(pageURL))
+	j.planTotals.RemoteImages++
+}
+
+// planShortLinks lists the short links of entry and comments texts that would
+// be expanded
+func (j *restoreJob) planShortLinks(entry *clio.Entry) {
+	texts := []string{entry.Body}
+	for _, c := range entry.Comments {
+		texts = append(texts, c.Body)
+	}
+	for _, text := range texts {
+		for _, w := range strings.Fields(text) {
+			if clio.Unshorten.NeedsLookup(w) {
+				j.plan.ShortLinks = append(j.plan.ShortLinks, w)
+				j.planTotals.ShortLinks++
+			}
+		}
+	}
+}
+
+func (p *entryPlan) addAttachment(format string, args ...interface{}) {
+	p.Attachments = append(p.Attachments, fmt.Sprintf(format, args...))
+}
+
+func (j *restoreJob) planComment(comment *clio.Comment, visible bool) {
+	j.plan.Comments = append(j.plan.Comments, activityPlan{comment.Author.OldUserName, visible})
+	if visible {
+		j.planTotals.VisibleComments++
+	} else {
+		j.planTotals.HiddenComments++
+	}
+}
+
+func (j *restoreJob) planLike(like *clio.Like, visible bool) {
+	j.plan.Likes = append(j.plan.Likes, activityPlan{like.Author.OldUserName, visible})
+	if visible {
+		j.planTotals.VisibleLikes++
+	} else {
+		j.planTotals.HiddenLikes++
+	}
+}
+
+func (p *entryPlan) print(w io.Writer) {
+	e := p.Entry
+	fmt.Fprintf(w, "Entry %s (%s) %s\n", e.Name, e.Date.Format("2006-01-02 15:04:05"), e.URL)
+	fmt.Fprintf(w, "  Post by %s, via %s\n", e.Author.NewUserName, e.Via.Name)
+	for _, s := range p.Attachments {
+		fmt.Fprintf(w, "  %s\n", s)
+	}
+	for _, s := range p.ShortLinks {
+		fmt.Fprintf(w, "  Short link, would look up: %s\n", s)
+	}
+	for _, c := range p.Comments {
+		fmt.Fprintf(w, "  Comment by %s: %s\n", c.Author, visibility(c.Visible))
+	}
+	for _, l := range p.Likes {
+		fmt.Fprintf(w, "  Like by %s: %s\n", l.Author, visibility(l.Visible))
+	}
+}
+
+func (t *planTotals) print(w io.Writer) {
+	fmt.Fprintln(w, "Dry run totals:")
+	fmt.Fprintf(w, "  Posts: %d\n", t.Posts)
+	fmt.Fprintf(w, "  Images: %d local, %d remote, %d not found\n", t.LocalImages, t.RemoteImages, t.MissingImages)
+	fmt.Fprintf(w, "  Files: %d, %d not found\n", t.Files, t.MissingFiles)
+	fmt.Fprintf(w, "  Short links: %d to look up\n", t.ShortLinks)
+	fmt.Fprintf(w, "  Comments: %d visible, %d hidden\n", t.VisibleComments, t.HiddenComments)
+	fmt.Fprintf(w, "  Likes: %d visible, %d hidden\n", t.VisibleLikes, t.HiddenLikes)
+}
+
+func (t *planTotals) add(o *planTotals) {
+	t.Posts += o.Posts
+	t.LocalImages += o.LocalImages
+	t.RemoteImages += o.RemoteImages
+	t.MissingImages += o.MissingImages
+	t.Files += o.Files
+	t.MissingFiles += o.MissingFiles
+	t.ShortLinks += o.ShortLinks
+	t.VisibleComments += o.VisibleComments
+	t.HiddenComments += o.HiddenComments
+	t.VisibleLikes += o.VisibleLikes
+	t.HiddenLikes += o.HiddenLikes
+}
+
+func visibility(visible bool) string {
+	if visible {
+		return "visible"
+	}
+	return "hidden"
+}
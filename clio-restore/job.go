@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"path"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/davidmz/mustbe"
+	"github.com/juju/errors"
+)
+
+// restoreJob holds the state of a single entry restoration. Jobs are run in
+// parallel by workers, so all per-entry state lives here rather than in App.
+// Job's log output is buffered and printed in the archive order.
+type restoreJob struct {
+	*App
+	File  string // entry file name in archive
+	Entry *clio.Entry
+	Tx    *sql.Tx
+
+	skipped    bool       // entry is filtered out
+	attOrd     int        // order of the next attachment in post
+	userStats  userStats  // user_stats increments to apply at the end of transaction
+	journal    []string   // storage paths written during the entry restoration
+	plan       *entryPlan // plan of the entry (in dry-run mode)
+	planTotals planTotals // plan counters of the entry (in dry-run mode)
+	report     *entryReport
+	imageErr   string      // reason of the last image processing failure
+	panicVal   interface{} // panic happened during the job
+
+	out      bytes.Buffer
+	infoLog  *logging.Log
+	errorLog *logging.Log
+	done     chan struct{}
+}
+
+func (a *App) newJob(file string) *restoreJob {
+	j := &restoreJob{
+		App:    a,
+		File:   file,
+		report: newEntryReport(path.Base(strings.TrimSuffix(file, ".js"))),
+		done:   make(chan struct{}),
+	}
+	logger := logging.NewLogger(&j.out)
+	j.infoLog = logging.Info.For(logger)
+	j.errorLog = logging.Error.For(logger)
+	return j
+}
+
+// logWith adds fields (key-value pairs) to the job's log records
+func (j *restoreJob) logWith(args ...interface{}) {
+	j.infoLog = j.infoLog.With(args...)
+	j.errorLog = j.errorLog.With(args...)
+}
+
+func (j *restoreJob) run() {
+	defer close(j.done)
+	defer func() {
+		if p := recover(); p != nil {
+			j.panicVal = p
+			j.out.Write(debug.Stack())
+			j.report.Status, j.report.Reason = statusFailed, fmt.Sprint(p)
+		}
+	}()
+
+	entry := new(clio.Entry)
+	mustbe.OK(errors.Annotate(j.Archive.ReadJSON(j.File, entry), "error reading entry"))
+	j.Entry = entry
+	j.report.Entry, j.report.URL = entry.Name, entry.URL
+	j.logWith("entry", entry.Name)
+
+	switch {
+	case !j.FromDate.IsZero() && entry.Date.Before(j.FromDate):
+		j.report.Reason = "created before from-date"
+	case !j.ToDate.IsZero() && entry.Date.After(j.ToDate):
+		j.report.Reason = "created after to-date"
+	case !j.IgnoreSources && !j.ViaToRestore[entry.Via.URL]:
+		j.report.Reason = "via source is not selected: " + entry.Via.URL
+	}
+	if j.report.Reason != "" {
+		j.skipped = true
+		j.report.Status = statusSkipped
+		return
+	}
+
+	entry.Init(j.Accounts)
+
+	j.restoreEntry(entry)
+}
+
+// RestoreEntries restores all suitable archive entries using a.Workers
+// parallel workers. The log output of entries is printed in the archive order.
+func (a *App) RestoreEntries() {
+	workers := a.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		queue = make(chan *restoreJob, workers) // jobs in the archive order
+		tasks = make(chan *restoreJob)          // jobs for workers
+		stop  = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range tasks {
+				j.run()
+			}
+		}()
+	}
+
+	go func() {
+		defer close(queue)
+		defer close(tasks)
+		for _, file := range a.Archive.Names {
+			if !entryRe.MatchString(file) {
+				continue
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			j := a.newJob(file)
+			queue <- j
+			tasks <- j
+		}
+	}()
+
+	var (
+		processedPosts int
+		failure        interface{}
+	)
+	for j := range queue {
+		<-j.done
+		a.report.add(j.report)
+		entriesMetric.Inc(j.report.Status)
+		progressMetric.Set(timestamp())
+		if j.skipped {
+			continue
+		}
+
+		if j.Entry != nil {
+			infoLog.Printf("Processing entry %s [%d/%d]", j.Entry.Name, processedPosts+1, a.PostsToRestore)
+		} else {
+			infoLog.Printf("Processing file %s", j.File)
+		}
+		logging.Output().Write(j.out.Bytes())
+		processedPosts++
+
+		a.planTotals.add(&j.planTotals)
+
+		if j.panicVal != nil && failure == nil {
+			// Stop dispatching, but wait for jobs that are already running
+			failure = j.panicVal
+			close(stop)
+		}
+	}
+
+	wg.Wait()
+
+	if failure != nil {
+		panic(failure)
+	}
+}
@@ -1,25 +1,25 @@
 package main
 
 import (
-	"archive/zip"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"runtime/debug"
 	"time"
 
-	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/archive"
 	"github.com/FreeFeed/clio-restore/internal/config"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/FreeFeed/clio-restore/internal/metrics"
 	"github.com/davidmz/mustbe"
 	"github.com/juju/errors"
 )
 
 // Globals
 var (
-	infoLog  = log.New(os.Stdout, "INFO  ", log.LstdFlags)
-	errorLog = log.New(os.Stdout, "ERROR ", log.LstdFlags)
-	fatalLog = log.New(os.Stdout, "FATAL ", log.LstdFlags)
+	infoLog  = logging.Info
+	errorLog = logging.Error
+	fatalLog = logging.Fatal
 )
 
 func main() {
@@ -32,18 +32,42 @@ func main() {
 		fromDateStr   string
 		toDateStr     string
 		ignoreSources bool
+		dryRun        bool
+		offline       bool
+		workers       int
+		spoolDir      string
+		spoolPoll     time.Duration
+		spoolOnce     bool
+		reportFile    string
 	)
 
 	flag.StringVar(&fromDateStr, "from-date", "", "restore entries created after this date (YYYY-MM-DD)")
 	flag.StringVar(&toDateStr, "to-date", "", "restore entries created before this date (YYYY-MM-DD)")
 	flag.BoolVar(&ignoreSources, "ignore-sources", false, "restore all entries regardless of the user's via-sources selection")
+	flag.BoolVar(&dryRun, "dry-run", false, "do not write anything to DB and storage, just print the restoration plan")
+	flag.BoolVar(&offline, "offline", false, "do not use network, take remote media only from the media cache (see clio-prefetch)")
+	flag.IntVar(&workers, "workers", 0, "number of entries restored in parallel (default is Workers from ini file or 1)")
+	flag.StringVar(&reportFile, "report", "", "write the restoration report to this file (CSV if the file has .csv extension, JSON otherwise)")
+	flag.StringVar(&spoolDir, "spool", "", "restore all archives appearing in this directory (batch mode)")
+	flag.DurationVar(&spoolPoll, "spool-poll", time.Minute, "interval of the spool directory checks")
+	flag.BoolVar(&spoolOnce, "spool-once", false, "restore archives that are in the spool directory now and exit")
 	flag.Parse()
 
-	if flag.Arg(0) == "" {
-		fmt.Fprintln(os.Stderr, "Usage: clio-restore [options] clio-archive.zip")
+	mustbe.OK(logging.Setup("clio-restore", os.Stdout))
+	defer logging.Close()
+	mustbe.OK(metrics.Start())
+	defer metrics.Stop()
+
+	if flag.Arg(0) == "" && spoolDir == "" {
+		fmt.Fprintln(os.Stderr, "Usage: clio-restore [options] clio-archive")
+		fmt.Fprintln(os.Stderr, "       clio-restore [options] -spool spool-dir")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	if spoolDir != "" && dryRun {
+		fmt.Fprintln(os.Stderr, "-spool cannot be used with -dry-run")
+		os.Exit(1)
+	}
 
 	var (
 		fromDate time.Time
@@ -60,41 +84,83 @@ func main() {
 	}
 
 	conf := mustbe.OKVal(config.Load()).(*config.Config)
+	if workers > 0 {
+		conf.Workers = workers
+	}
 
-	archFile := flag.Arg(0)
-
-	// Open zip
-	archZip, err := zip.OpenReader(archFile)
-	mustbe.OK(errors.Annotate(err, "cannot open archive file"))
-	defer archZip.Close()
+	app := App{
+		FromDate:      fromDate,
+		ToDate:        toDate,
+		IgnoreSources: ignoreSources,
+		DryRun:        dryRun,
+		Offline:       offline,
+		ReportFile:    reportFile,
+	}
 
-	app := new(App)
-	app.Init(archZip.File, conf)
-	defer app.Close()
+	if spoolDir != "" {
+		sp := &spool{Dir: spoolDir, Conf: conf, App: app}
+		sp.Init()
+		for {
+			sp.Process()
+			if spoolOnce {
+				return
+			}
+			time.Sleep(spoolPoll)
+		}
+	}
 
-	processedPosts := 0
+	restoreArchive(flag.Arg(0), nil, conf, &app)
+}
 
-	for _, file := range app.ZipFiles {
-		if !entryRe.MatchString(file.Name) {
-			continue
+// restoreArchive restores archive from archFile using the app settings
+// restoreArchive restores the archive file. If arch is nil, the file is
+// opened.
+func restoreArchive(archFile string, arch *archive.Archive, conf *config.Config, app *App) {
+	runningMetric.Set(1)
+	defer func() {
+		result := "ok"
+		p := recover()
+		if p != nil {
+			result = "failed"
+		}
+		runsMetric.Inc(result)
+		runningMetric.Set(0)
+		lastRunMetric.Set(timestamp())
+		if p != nil {
+			panic(p)
 		}
+	}()
 
-		entry := new(clio.Entry)
-		mustbe.OK(errors.Annotate(readZipObject(file, entry), "error reading entry"))
+	if arch == nil {
+		var err error
+		arch, err = archive.Open(archFile)
+		mustbe.OK(errors.Annotate(err, "cannot open archive file"))
+		defer arch.Close()
+	}
 
-		if !fromDate.IsZero() && entry.Date.Before(fromDate) || // entry was created before from-date
-			!toDate.IsZero() && entry.Date.After(toDate) || // entry was created after to-date
-			!ignoreSources && !app.ViaToRestore[entry.Via.URL] { // via source not allowed, skipping
-			//
-			continue
-		}
+	app.report.Archive, app.report.Started = archFile, time.Now()
+	app.Init(arch, conf)
+	defer app.Close()
+
+	if app.ReportFile != "" {
+		app.report.Owner, app.report.NewOwner = app.Owner.OldUserName, app.Owner.NewUserName
+		// Report is written even if restoration fails
+		defer app.writeReport()
+	}
 
-		entry.Init(app.Accounts)
+	app.RestoreEntries()
 
-		infoLog.Printf("Processing entry %s [%d/%d]", entry.Name, processedPosts+1, app.PostsToRestore)
-		app.restoreEntry(entry)
+	if app.DryRun {
+		app.planTotals.print(logging.Output())
+		infoLog.Println("Dry run done.")
+		return
+	}
 
-		processedPosts++
+	if app.Dedup.Images > 0 {
+		infoLog.Printf(
+			"Deduplicated images: %d (%d files, %d bytes are copied instead of upload)",
+			app.Dedup.Images, app.Dedup.Files, app.Dedup.Bytes,
+		)
 	}
 
 	// all done
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/FreeFeed/clio-restore/internal/mediacache"
+	"github.com/FreeFeed/clio-restore/internal/metrics"
+)
+
+// Restoration metrics
+var (
+	runsMetric = metrics.Default.Counter(
+		"clio_restore_runs_total", "Archive restorations by result (ok or failed).", "result")
+	runningMetric = metrics.Default.Gauge(
+		"clio_restore_running", "1 if the archive restoration is in progress.")
+	lastRunMetric = metrics.Default.Gauge(
+		"clio_restore_last_run_timestamp_seconds", "Time of the last finished archive restoration.")
+	progressMetric = metrics.Default.Gauge(
+		"clio_restore_last_progress_timestamp_seconds", "Time of the last processed entry.")
+	entriesMetric = metrics.Default.Counter(
+		"clio_restore_entries_total", "Processed entries by status.", "status")
+	fetchesMetric = metrics.Default.Counter(
+		"clio_restore_fetches_total", "Remote media fetches by host and HTTP status (error for the network errors).", "host", "status", "cached")
+	imageOpsMetric = metrics.Default.Counter(
+		"clio_restore_image_ops_total", "Image processing operations by tool, operation and result.", "tool", "op", "result")
+	storedFilesMetric = metrics.Default.Counter(
+		"clio_restore_stored_files_total", "Files written to the attachments storage by method (upload or copy).", "method")
+	uploadedBytesMetric = metrics.Default.Counter(
+		"clio_restore_uploaded_bytes_total", "Bytes uploaded to the attachments storage.")
+	txDurationMetric = metrics.Default.Histogram(
+		"clio_restore_transaction_duration_seconds", "Duration of the entry transactions by result (commit or rollback).", metrics.DefBuckets, "result")
+	commentsMetric = metrics.Default.Counter(
+		"clio_restore_comments_total", "Restored comments by visibility (visible or hidden).", "visibility")
+	likesMetric = metrics.Default.Counter(
+		"clio_restore_likes_total", "Restored likes by visibility (visible or hidden).", "visibility")
+)
+
+// fetch fetches URL by the Fetcher and counts the fetch in metrics
+func (j *restoreJob) fetch(URL string) (*mediacache.Response, error) {
+	host := "unknown"
+	if u, err := url.Parse(URL); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+
+	resp, err := j.Fetcher.Get(URL)
+	if err != nil {
+		fetchesMetric.Inc(host, "error", "false")
+		return nil, err
+	}
+	fetchesMetric.Inc(host, strconv.Itoa(resp.Status), strconv.FormatBool(resp.Cached))
+	return resp, nil
+}
+
+// imageTool returns the name of the program that processes images of the
+// given format
+func (a *App) imageTool(format string) string {
+	switch {
+	case a.ImageProcessor == "native":
+		return "native"
+	case format == "gif":
+		return "gifsicle"
+	}
+	return "gm"
+}
+
+func timestamp() float64 { return float64(time.Now().UnixNano()) / 1e9 }
@@ -7,240 +7,303 @@ import (
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
 
+	"github.com/FreeFeed/clio-restore/internal/config"
 	"github.com/FreeFeed/clio-restore/internal/dbutil"
+	"github.com/FreeFeed/clio-restore/internal/imgproc"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/FreeFeed/clio-restore/internal/scrub"
+	"github.com/FreeFeed/clio-restore/internal/thumbs"
 	"github.com/davidmz/mustbe"
 	"github.com/gofrs/uuid"
-	"github.com/rwcarlsen/goexif/exif"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
 type imageSizes map[string]imageSizesEntry
 
 type imageSizesEntry struct {
-	Width   int    `json:"w"`
-	Height  int    `json:"h"`
-	DirName string `json:"-"`
-	URL     string `json:"url"`
-	Body    []byte `json:"-"`
+	Width     int               `json:"w"`
+	Height    int               `json:"h"`
+	DirName   string            `json:"-"`
+	URL       string            `json:"url"`
+	Alt       map[string]string `json:"alt,omitempty"` // format -> URL of the alternate encoding
+	Body      []byte            `json:"-"`
+	AltBodies map[string][]byte `json:"-"`
 }
 
-var defaultImageSizes = map[string]imageSizesEntry{
-	"o":  {0, 0, "attachments", "", nil},
-	"t":  {525, 175, "attachments/thumbnails", "", nil},
-	"t2": {1050, 350, "attachments/thumbnails2", "", nil},
+// path returns storage path of the file with the given extension
+func (e imageSizesEntry) path(uid, ext string) string {
+	return e.DirName + "/" + uid + "." + ext
 }
 
 var supportedFormats = map[string]struct {
-	MIMEType string
-	Ext      string
-	GMFormat string
+	MIMEType  string
+	Ext       string
+	Transcode bool // always convert to the web-safe format
 }{
-	"jpeg": {"image/jpeg", "jpg", "jpeg"},
-	"png":  {"image/png", "png", "png"},
-	"gif":  {"image/gif", "gif", "gif"},
+	"jpeg": {"image/jpeg", "jpg", false},
+	"png":  {"image/png", "png", false},
+	"gif":  {"image/gif", "gif", false},
+	"webp": {"image/webp", "webp", false},
+	"bmp":  {"image/bmp", "bmp", true},
+	"tiff": {"image/tiff", "tiff", true},
 }
 
 func (i imageSizes) setName(baseURL, uid, ext string) {
 	for t, e := range i {
-		e.URL = baseURL + "/" + e.DirName + "/" + uid + "." + ext
+		e.URL = baseURL + "/" + e.path(uid, ext)
+		if len(e.Alt) > 0 {
+			alt := make(map[string]string, len(e.Alt))
+			for f := range e.Alt {
+				alt[f] = baseURL + "/" + e.path(uid, f)
+			}
+			e.Alt = alt
+		}
 		i[t] = e
 	}
 }
 
-// Create image attachment from the first suitable from provided URLs
-func (a *App) createImageAttachment(URLs ...string) (uid string, ok bool) {
+// Prepare image attachment from the first suitable from provided URLs
+func (j *restoreJob) createImageAttachment(URLs ...string) (att *attachment, ok bool) {
+	if j.DryRun {
+		return j.planImageAttachment(URLs...)
+	}
 	for _, u := range URLs {
-		uid, ok = a.processSingleImage(u)
+		j.imageErr = ""
+		att, ok = j.processSingleImage(u)
 		if ok {
-			infoLog.Printf("Created image %s from URL %s", uid, u)
+			j.infoLog.Printf("Prepared image %s from URL %s", att.UID, u)
 			break
 		}
+		if j.imageErr == "" {
+			j.imageErr = "cannot process image"
+		}
+		j.report.FailedImages = append(j.report.FailedImages, failedImage{u, j.imageErr})
 	}
 	return
 }
 
-var httpClient = &http.Client{
-	Timeout: 20 * time.Second,
+// imageFailed logs the reason of the image processing failure and keeps it
+// for the report
+func (j *restoreJob) imageFailed(log *logging.Log, format string, v ...interface{}) {
+	j.imageErr = fmt.Sprintf(format, v...)
+	log.Println(j.imageErr)
 }
 
-func (a *App) processSingleImage(URL string) (uid string, ok bool) {
+func (j *restoreJob) processSingleImage(URL string) (att *attachment, ok bool) {
+	infoLog, errorLog := j.infoLog.With("url", URL), j.errorLog.With("url", URL)
+
 	if ffMediaURLRe.MatchString(URL) {
 		// Local image
 		id := ffMediaURLRe.FindStringSubmatch(URL)[1]
-		if lf, exists := a.ImageFiles[id]; exists {
-			r := mustbe.OKVal(lf.Open()).(io.ReadCloser)
-			body := mustbe.OKVal(ioutil.ReadAll(r)).([]byte)
-			r.Close()
-			uid, ok = a.makeAttachment(filepath.Base(lf.Name), body)
+		if lf, exists := j.ImageFiles[id]; exists {
+			body := mustbe.OKVal(lf.read()).([]byte)
+			att, ok = j.makeAttachment(filepath.Base(lf.Name), body)
 		} else {
-			errorLog.Printf("Local image not found: %s", URL)
+			j.imageFailed(errorLog, "Local image not found")
 		}
 		return
 	}
 
 	// Trying to Load remote image
-	infoLog.Println("Loading image:", URL)
-	resp, err := httpClient.Get(URL)
+	infoLog.Println("Loading image")
+	resp, err := j.fetch(URL)
 	if err != nil {
-		errorLog.Println("Cannot fetch URL", URL)
-		return
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK { // redirects?
-		errorLog.Printf("Error fetching URL: %s (%s)", resp.Status, URL)
+		j.imageFailed(errorLog, "Cannot fetch URL: %v", err)
 		return
 	}
-	if flickrImageRe.MatchString(URL) && resp.Request.URL.Hostname() == "s.yimg.com" {
-		// flickr "image not found"
-		errorLog.Printf("Error fetching URL: flickr image not found (%s)", URL)
-		return
-	}
-
-	ct := strings.Split(strings.ToLower(resp.Header.Get("Content-Type")), ";")[0]
-	if !(ct == "image/jpeg" || ct == "image/jpg" || ct == "image/png" || ct == "image/gif") {
-		errorLog.Printf("Unsupported content type: %s (%s)", resp.Header.Get("Content-Type"), URL)
-		return
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		errorLog.Printf("Cannot read URL data: %v (%s)", err, URL)
+	if err := thumbs.CheckImageResponse(URL, resp.Status, resp.ContentType, resp.FinalURL); err != nil {
+		j.imageFailed(errorLog, "Unusable image: %v", err)
 		return
 	}
 
-	uid, ok = a.makeAttachment("", body)
+	att, ok = j.makeAttachment("", resp.Body)
 
 	return
 }
 
-func (a *App) makeAttachment(name string, body []byte) (uid string, ok bool) {
+// makeAttachment decodes image, creates its thumbnails and returns
+// the prepared attachment. It doesn't write anything to storage or DB.
+func (j *restoreJob) makeAttachment(name string, body []byte) (att *attachment, ok bool) {
+	hash := hashOf(body)
+	if src := j.findBySource(hash); src != nil {
+		return j.reuseImage(src, name), true
+	}
+
 	// do not trust content-type
 	cfg, fmtString, err := image.DecodeConfig(bytes.NewReader(body))
 	if err != nil {
-		errorLog.Printf("Cannot decode image: %v", err)
+		j.imageFailed(j.errorLog, "Cannot decode image: %v", err)
 		return
 	}
 
 	format, ok := supportedFormats[fmtString]
 	if !ok {
-		errorLog.Printf("Unsupported image format: %s", format)
+		j.imageFailed(j.errorLog, "Unsupported image format: %s", fmtString)
 		return
 	}
 
+	if format.Transcode || !j.ImageProc.Encodes(fmtString) {
+		newFmt := transcodeFormat(body)
+		newBody, err := j.resizeImage(body, imgproc.Options{
+			Format:  newFmt,
+			Width:   cfg.Width,
+			Height:  cfg.Height,
+			Quality: j.ImageSizes[config.OriginalSize].Quality,
+		})
+		if err != nil {
+			j.imageFailed(j.errorLog, "Cannot convert image from %s to %s: %s", fmtString, newFmt, err)
+			return nil, false
+		}
+		j.infoLog.Printf("Image converted from %s to %s", fmtString, newFmt)
+		body, fmtString, format = newBody, newFmt, supportedFormats[newFmt]
+	}
+
 	if format.MIMEType == "image/jpeg" {
-		if orient := getEXIFOrientation(bytes.NewReader(body)); orient != 0 && orient != 1 {
-			cmd := exec.Command(a.GM,
-				"convert",
-				"-", // stdin
-				"-profile", a.SRGB,
-				"-auto-orient",
-				"-quality", "95",
-				"jpeg:-", // stdout
-			)
-			cmd.Stdin = bytes.NewReader(body)
-			newBody := new(bytes.Buffer)
-			cmd.Stdout = newBody
-			if err := cmd.Run(); err != nil {
-				errorLog.Printf("Cannot auto-orient image: %s", err)
-			} else {
-				body = newBody.Bytes()
+		if orient := imgproc.Orientation(body); orient != 0 && orient != 1 {
+			var newBody []byte
+			err := j.imageOp("orient", fmtString, func() (err error) {
+				newBody, err = j.ImageProc.AutoOrient(body, orient)
+				return
+			})
+			if err != nil {
+				j.errorLog.Printf("Cannot auto-orient image: %s", err)
+			} else if newCfg, _, err := image.DecodeConfig(bytes.NewReader(newBody)); err == nil {
+				body, cfg = newBody, newCfg
 			}
 		}
 	}
 
-	// Store original image size
-	iSizes := make(imageSizes)
-
-	szEntry := defaultImageSizes["o"]
-	szEntry.Width = cfg.Width
-	szEntry.Height = cfg.Height
-	szEntry.Body = body
-	iSizes["o"] = szEntry
+	// Remove private metadata, thumbnails are made from the scrubbed image
+	if newBody, removed, err := scrub.Scrub(body, fmtString, j.Metadata); err != nil {
+		j.imageFailed(j.errorLog, "Cannot remove image metadata: %s", err)
+		return nil, false
+	} else if len(removed) > 0 {
+		j.infoLog.Printf("Removed image metadata: %s", strings.Join(removed, ", "))
+		body = newBody
+	}
 
-	for szID, szEntry := range defaultImageSizes {
-		if szID == "o" || cfg.Width <= szEntry.Width && cfg.Height <= szEntry.Height {
-			continue
-		}
-		szEntry.Width, szEntry.Height = fitInto(cfg.Width, cfg.Height, szEntry.Width, szEntry.Height)
-
-		// Do resize
-		var cmd *exec.Cmd
-		if format.MIMEType != "image/gif" {
-			cmd = exec.Command(a.GM,
-				"convert",
-				"-", // stdin
-				"-resize", fmt.Sprintf("%dx%d!", szEntry.Width, szEntry.Height),
-				"-profile", a.SRGB,
-				"-auto-orient",
-				"-quality", "95",
-				format.GMFormat+":-", // stdout
-			)
+	// Downscale the original image if it is larger than the "o" preset
+	orig := j.ImageSizes[config.OriginalSize]
+	if orig.Width > 0 && orig.Height > 0 && (cfg.Width > orig.Width || cfg.Height > orig.Height) {
+		w, h := fitInto(cfg.Width, cfg.Height, orig.Width, orig.Height)
+		newBody, err := j.resizeImage(body, imgproc.Options{Format: fmtString, Width: w, Height: h, Quality: orig.Quality})
+		if err != nil {
+			j.errorLog.Printf("Cannot resize original image: %s", err)
 		} else {
-			cmd = exec.Command(a.GifSicle,
-				"--resize", fmt.Sprintf("%dx%d", szEntry.Width, szEntry.Height),
-				"-O3",
-			)
-
+			body, cfg.Width, cfg.Height = newBody, w, h
 		}
-		cmd.Stdin = bytes.NewReader(body)
-		newBodyBuf := new(bytes.Buffer)
-		cmd.Stdout = newBodyBuf
-		if err := cmd.Run(); err != nil {
-			errorLog.Printf("Cannot resize image: %s", err)
-			continue
+	}
+
+	// The existing images are found by the processed original image
+	origHash := hashOf(body)
+	if src := j.findByOriginal(origHash, len(body)); src != nil {
+		return j.reuseImage(src, name), true
+	}
+
+	iSizes := make(imageSizes)
+	for szID, preset := range j.ImageSizes {
+		szEntry := imageSizesEntry{DirName: preset.Dir}
+		if szID == config.OriginalSize {
+			szEntry.Width, szEntry.Height, szEntry.Body = cfg.Width, cfg.Height, body
+		} else {
+			if cfg.Width <= preset.Width && cfg.Height <= preset.Height {
+				continue
+			}
+			szEntry.Width, szEntry.Height = fitInto(cfg.Width, cfg.Height, preset.Width, preset.Height)
+			newBody, err := j.resizeImage(body, imgproc.Options{Format: fmtString, Width: szEntry.Width, Height: szEntry.Height, Quality: preset.Quality})
+			if err != nil {
+				j.errorLog.Printf("Cannot resize image: %s", err)
+				continue
+			}
+			szEntry.Body = newBody
 		}
-		if newBodyBuf.Len() == 0 {
-			errorLog.Printf("Cannot resize image: empty result")
-			continue
+
+		// Alternate encodings (GIFs are not converted to keep the animation)
+		for _, altFmt := range preset.Alt {
+			if fmtString == "gif" {
+				break
+			}
+			altBody, err := j.resizeImage(szEntry.Body, imgproc.Options{Format: altFmt, Width: szEntry.Width, Height: szEntry.Height, Quality: preset.Quality})
+			if err != nil {
+				j.errorLog.Printf("Cannot convert image to %s: %s", altFmt, err)
+				continue
+			}
+			if szEntry.Alt == nil {
+				szEntry.Alt = make(map[string]string)
+				szEntry.AltBodies = make(map[string][]byte)
+			}
+			szEntry.Alt[altFmt] = ""
+			szEntry.AltBodies[altFmt] = altBody
 		}
-		szEntry.Body = newBodyBuf.Bytes()
+
 		iSizes[szID] = szEntry
 	}
 
-	uid, ok = mustbe.OKVal(uuid.NewV4()).(uuid.UUID).String(), true
+	uid := mustbe.OKVal(uuid.NewV4()).(uuid.UUID).String()
 
-	iSizes.setName(a.AttURL, uid, format.Ext)
+	iSizes.setName(j.AttURL, uid, format.Ext)
 
-	// Upload all versions
-	for _, entry := range iSizes {
-		a.storeAttachment(entry.Body, entry.DirName+"/"+uid+"."+format.Ext, name, format.MIMEType)
+	att = &attachment{
+		UID:         uid,
+		Name:        name,
+		ContentType: format.MIMEType,
+		Row: dbutil.H{
+			"uid":            uid,
+			"file_name":      name,
+			"file_size":      len(body),
+			"mime_type":      format.MIMEType,
+			"media_type":     "image",
+			"file_extension": format.Ext,
+			"no_thumbnail":   len(iSizes) == 1,
+			"image_sizes":    dbutil.JSONVal(iSizes),
+		},
+	}
+	att.image = &storedImage{
+		UID:      uid,
+		Ext:      format.Ext,
+		MIMEType: format.MIMEType,
+		FileSize: len(body),
+		Sizes:    make(imageSizes),
+		Hash:     hash,
+		OrigHash: origHash,
+	}
+	for szID, entry := range iSizes {
+		att.Files = append(att.Files, attachmentFile{
+			Path: entry.path(uid, format.Ext),
+			Body: entry.Body,
+		})
+		att.image.Bytes += len(entry.Body)
+		for altFmt, altBody := range entry.AltBodies {
+			att.Files = append(att.Files, attachmentFile{
+				Path:        entry.path(uid, altFmt),
+				Body:        altBody,
+				ContentType: config.AltFormats[altFmt],
+			})
+			att.image.Bytes += len(altBody)
+		}
+		entry.Body, entry.AltBodies = nil, nil
+		att.image.Sizes[szID] = entry
 	}
 
-	// Write to DB without post_id, user_id, created_at and updated_at
-	dbutil.MustInsert(a.Tx, "attachments", dbutil.H{
-		"uid":            uid,
-		"ord":            a.AttOrd,
-		"file_name":      name,
-		"file_size":      len(body),
-		"mime_type":      format.MIMEType,
-		"media_type":     "image",
-		"file_extension": format.Ext,
-		"no_thumbnail":   len(iSizes) == 1,
-		"image_sizes":    dbutil.JSONVal(iSizes),
-	})
-	a.AttOrd++
-
-	return
+	return att, true
 }
 
-func getEXIFOrientation(r io.Reader) (orient int) {
-	x, err := exif.Decode(r)
+// transcodeFormat returns the web-safe format to convert image to: JPEG for
+// the opaque images and PNG for the images with transparency
+func transcodeFormat(body []byte) string {
+	img, _, err := image.Decode(bytes.NewReader(body))
 	if err != nil {
-		return
+		return "jpeg"
 	}
-	oTag, err := x.Get(exif.Orientation)
-	if err != nil {
-		return
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		return "png"
 	}
-	orient, _ = oTag.Int(0)
-	return
+	return "jpeg"
 }
 
 func fitInto(w, h, fitW, fitH int) (newW, newH int) {
@@ -257,3 +320,27 @@ func fitInto(w, h, fitW, fitH int) (newW, newH int) {
 	}
 	return
 }
+
+// resizeImage resizes (or converts) image in the image processing queue
+func (j *restoreJob) resizeImage(body []byte, opts imgproc.Options) (newBody []byte, err error) {
+	err = j.imageOp("resize", opts.Format, func() (err error) {
+		newBody, err = j.ImageProc.Resize(body, opts)
+		return
+	})
+	return
+}
+
+// imageOp runs the image processing operation (name) on the image of the
+// given format. The number of operations running in parallel is limited by
+// the ImageWorkers setting.
+func (a *App) imageOp(name, format string, op func() error) error {
+	a.imageSem <- struct{}{}
+	defer func() { <-a.imageSem }()
+	err := op()
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	imageOpsMetric.Inc(a.imageTool(format), name, result)
+	return err
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Entry statuses in report
+const (
+	statusRestored = "restored"
+	statusPlanned  = "planned" // dry-run
+	statusSkipped  = "skipped" // filtered out by date or via source
+	statusExists   = "exists"  // already imported
+	statusFailed   = "failed"
+)
+
+// runReport is the machine-readable report of the restoration run
+type runReport struct {
+	Archive  string         `json:"archive"`
+	Owner    string         `json:"owner"`
+	NewOwner string         `json:"newOwner"`
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Entries  []*entryReport `json:"entries"`
+	Totals   reportTotals   `json:"totals"`
+}
+
+// entryReport is the report of a single entry
+type entryReport struct {
+	Entry           string        `json:"entry"`
+	URL             string        `json:"url"`
+	Status          string        `json:"status"`
+	Reason          string        `json:"reason,omitempty"` // why entry is skipped or failed
+	Post            string        `json:"post,omitempty"`   // UID of the created post
+	Attachments     []string      `json:"attachments"`      // UIDs of the created attachments
+	FailedImages    []failedImage `json:"failedImages"`     // image URLs that were tried and failed
+	MissingFiles    []string      `json:"missingFiles"`     // attached files not found in archive
+	VisibleComments int           `json:"visibleComments"`
+	HiddenComments  int           `json:"hiddenComments"`
+	VisibleLikes    int           `json:"visibleLikes"`
+	HiddenLikes     int           `json:"hiddenLikes"`
+}
+
+type failedImage struct {
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+type reportTotals struct {
+	Entries         int `json:"entries"`
+	Restored        int `json:"restored"`
+	Planned         int `json:"planned"`
+	Skipped         int `json:"skipped"`
+	Exists          int `json:"exists"`
+	Failed          int `json:"failed"`
+	Attachments     int `json:"attachments"`
+	FailedImages    int `json:"failedImages"`
+	MissingFiles    int `json:"missingFiles"`
+	VisibleComments int `json:"visibleComments"`
+	HiddenComments  int `json:"hiddenComments"`
+	VisibleLikes    int `json:"visibleLikes"`
+	HiddenLikes     int `json:"hiddenLikes"`
+}
+
+func newEntryReport(entry string) *entryReport {
+	return &entryReport{
+		Entry:        entry,
+		Attachments:  []string{},
+		FailedImages: []failedImage{},
+		MissingFiles: []string{},
+	}
+}
+
+func (r *runReport) add(e *entryReport) {
+	r.Entries = append(r.Entries, e)
+
+	t := &r.Totals
+	t.Entries++
+	switch e.Status {
+	case statusRestored:
+		t.Restored++
+	case statusPlanned:
+		t.Planned++
+	case statusSkipped:
+		t.Skipped++
+	case statusExists:
+		t.Exists++
+	case statusFailed:
+		t.Failed++
+	}
+	t.Attachments += len(e.Attachments)
+	t.FailedImages += len(e.FailedImages)
+	t.MissingFiles += len(e.MissingFiles)
+	t.VisibleComments += e.VisibleComments
+	t.HiddenComments += e.HiddenComments
+	t.VisibleLikes += e.VisibleLikes
+	t.HiddenLikes += e.HiddenLikes
+}
+
+// writeReport writes report to a.ReportFile, as CSV if the file has the
+// .csv extension and as JSON otherwise
+func (a *App) writeReport() {
+	r := a.report
+	r.Finished = time.Now()
+
+	f, err := os.Create(a.ReportFile)
+	if err == nil {
+		if strings.ToLower(filepath.Ext(a.ReportFile)) == ".csv" {
+			err = r.writeCSV(f)
+		} else {
+			err = r.writeJSON(f)
+		}
+		if cErr := f.Close(); err == nil {
+			err = cErr
+		}
+	}
+	if err != nil {
+		errorLog.Println("Cannot write report:", errors.Annotate(err, a.ReportFile))
+		return
+	}
+	infoLog.Println("Report is written to", a.ReportFile)
+}
+
+func (r *runReport) writeJSON(w io.Writer) error {
+	if r.Entries == nil {
+		r.Entries = []*entryReport{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// writeCSV writes one row per entry and the row of totals at the end
+func (r *runReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"entry", "url", "status", "reason", "post", "attachments", "failed_images", "missing_files",
+		"visible_comments", "hidden_comments", "visible_likes", "hidden_likes",
+	})
+	for _, e := range r.Entries {
+		var failed []string
+		for _, fi := range e.FailedImages {
+			failed = append(failed, fi.URL+" "+fi.Reason)
+		}
+		cw.Write([]string{
+			e.Entry, e.URL, e.Status, e.Reason, e.Post,
+			strings.Join(e.Attachments, " "),
+			strings.Join(failed, "\n"),
+			strings.Join(e.MissingFiles, "\n"),
+			strconv.Itoa(e.VisibleComments), strconv.Itoa(e.HiddenComments),
+			strconv.Itoa(e.VisibleLikes), strconv.Itoa(e.HiddenLikes),
+		})
+	}
+	t := r.Totals
+	cw.Write([]string{
+		"TOTAL", r.Archive, "",
+		fmt.Sprintf(
+			"entries %d, restored %d, planned %d, skipped %d, exists %d, failed %d",
+			t.Entries, t.Restored, t.Planned, t.Skipped, t.Exists, t.Failed,
+		),
+		"",
+		strconv.Itoa(t.Attachments), strconv.Itoa(t.FailedImages), strconv.Itoa(t.MissingFiles),
+		strconv.Itoa(t.VisibleComments), strconv.Itoa(t.HiddenComments),
+		strconv.Itoa(t.VisibleLikes), strconv.Itoa(t.HiddenLikes),
+	})
+	cw.Flush()
+	return cw.Error()
+}
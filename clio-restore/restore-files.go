@@ -1,18 +1,16 @@
 package main
 
 import (
-	"io"
-	"io/ioutil"
-	"strings"
-
+	"github.com/FreeFeed/clio-restore/internal/audiometa"
 	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/config"
 	"github.com/FreeFeed/clio-restore/internal/dbutil"
-	"github.com/ascherkus/go-id3/src/id3"
+	"github.com/FreeFeed/clio-restore/internal/filetype"
 	"github.com/davidmz/mustbe"
 	"github.com/gofrs/uuid"
 )
 
-func (a *App) restoreFiles(entry *clio.Entry) (resUIDs []string) {
+func (j *restoreJob) restoreFiles(entry *clio.Entry) (res []*attachment) {
 	var foundFiles []*fileInfo
 	for _, f := range entry.Files {
 		m := fileIDRe.FindStringSubmatch(f.URL)
@@ -20,70 +18,76 @@ func (a *App) restoreFiles(entry *clio.Entry) (resUIDs []string) {
 			continue
 		}
 		id := m[0]
-		of, ok := a.OtherFiles[id]
+		of, ok := j.OtherFiles[id]
 		if !ok { // file not found in local files
+			j.report.MissingFiles = append(j.report.MissingFiles, f.Name)
+			if j.DryRun {
+				j.plan.addAttachment("File not found: %s (%s)", f.Name, f.URL)
+				j.planTotals.MissingFiles++
+			}
 			continue
 		}
 
 		foundFiles = append(foundFiles, &fileInfo{
-			zipFile:     of.File,
+			file:        of,
 			ContentType: f.Type,
 			Name:        f.Name,
 		})
 	}
 
 	for _, af := range foundFiles {
-		var (
-			title  string
-			artist string
-		)
-		if af.isMP3() {
-			// Read ID3 metadata
-			func() {
-				defer func() { recover() }() // due to issue https://github.com/ascherkus/go-id3/issues/1
-				r, err := af.zipFile.Open()
-				if err != nil {
-					return
-				}
-				defer r.Close()
+		if j.DryRun {
+			j.plan.addAttachment("File %s (%s, %d bytes)", af.Name, af.ContentType, af.size())
+			j.planTotals.Files++
+			continue
+		}
 
-				meta := id3.Read(r)
-				if meta == nil {
-					return
-				}
+		// We must read file into memory because AWS required io.ReadSeeker
+		body := mustbe.OKVal(af.file.read()).([]byte)
 
-				title = strings.Replace(meta.Name, "\u0000", "", -1)
-				artist = strings.Replace(meta.Artist, "\u0000", "", -1)
-			}()
+		ft := filetype.Detect(body, af.Name, af.ContentType)
+		if ft.MediaType == filetype.Video && !j.Schema.Video {
+			ft.MediaType = filetype.General
+		}
+		if ft.MIMEType != af.ContentType {
+			j.infoLog.Printf("File %s is detected as %s (declared as %s)", af.Name, ft.MIMEType, af.ContentType)
 		}
 
-		attID := mustbe.OKVal(uuid.NewV4()).(uuid.UUID).String()
+		var meta audiometa.Meta
+		if ft.MediaType == filetype.Audio {
+			var err error
+			if meta, err = audiometa.Read(body); err != nil {
+				j.errorLog.Printf("Cannot read audio metadata of %s: %v", af.Name, err)
+			}
+		}
 
-		// We must read file into memory because AWS required io.ReadSeeker
-		// and zipFile.Open returns io.ReadCloser
-		r := mustbe.OKVal(af.zipFile.Open()).(io.ReadCloser)
-		body := mustbe.OKVal(ioutil.ReadAll(r)).([]byte)
-		r.Close()
-		a.storeAttachment(body, "attachments/"+attID+af.dotExt(), af.Name, af.ContentType)
+		attID := mustbe.OKVal(uuid.NewV4()).(uuid.UUID).String()
+		path := j.ImageSizes[config.OriginalSize].Dir + "/" + attID
+		if ft.Ext != "" {
+			path += "." + ft.Ext
+		}
 
-		// Write to DB
-		dbutil.MustInsert(a.Tx, "attachments", dbutil.H{
+		row := dbutil.H{
 			"uid":            attID,
-			"ord":            a.AttOrd,
-			"created_at":     entry.Date,
-			"updated_at":     entry.Date,
 			"file_name":      af.Name,
 			"file_size":      af.size(),
-			"mime_type":      af.ContentType,
-			"media_type":     af.attachType(),
-			"file_extension": af.ext(),
-			"user_id":        entry.Author.UID,
-			"artist":         artist,
-			"title":          title,
-		})
-		a.AttOrd++
+			"mime_type":      ft.MIMEType,
+			"media_type":     ft.MediaType,
+			"file_extension": ft.Ext,
+			"artist":         meta.Artist,
+			"title":          meta.Title,
+		}
+		if meta.Duration > 0 && j.Schema.Duration {
+			row["duration"] = meta.Duration.Seconds()
+		}
 
-		resUIDs = append(resUIDs, attID)
+		res = append(res, &attachment{
+			UID:         attID,
+			Name:        af.Name,
+			ContentType: ft.MIMEType,
+			Files:       []attachmentFile{{Path: path, Body: body}},
+			Row:         row,
+		})
 	}
 
 	return
@@ -2,7 +2,8 @@ package main
 
 import (
 	"database/sql"
-	"strings"
+	"sort"
+	"time"
 
 	"github.com/FreeFeed/clio-restore/internal/account"
 	"github.com/FreeFeed/clio-restore/internal/clio"
@@ -10,37 +11,74 @@ import (
 	"github.com/FreeFeed/clio-restore/internal/hashtags"
 	"github.com/davidmz/mustbe"
 	"github.com/gofrs/uuid"
+	"github.com/juju/errors"
 	"github.com/lib/pq"
 )
 
-func (a *App) restoreEntry(entry *clio.Entry) {
+func (j *restoreJob) restoreEntry(entry *clio.Entry) {
 	// check if entry already imported
 	alreadyExists := false
-	mustbe.OK(a.DB.QueryRow(
+	mustbe.OK(j.DB.QueryRow(
 		`select exists(select 1 from archive_post_names where old_post_name = $1)`,
 		entry.Name,
 	).Scan(&alreadyExists))
 
 	if alreadyExists {
-		errorLog.Println("entry already imported")
+		j.errorLog.Println("entry already imported")
+		j.report.Status = statusExists
 		return
 	}
 
-	a.Tx = mustbe.OKVal(a.DB.Begin()).(*sql.Tx)
+	if j.DryRun {
+		j.planEntry(entry)
+		j.report.Status = statusPlanned
+		return
+	}
+
+	// Prepare thumbnails & files before the transaction starts: remote
+	// images fetching and resizing may take a long time.
+	var attachments []*attachment
+	attachments = append(attachments, j.restoreThumbnails(entry)...)
+	attachments = append(attachments, j.restoreFiles(entry)...)
+
+	// Files are stored outside of the transaction, so we should delete them
+	// if transaction fails
 	defer func() {
 		if p := recover(); p != nil {
-			a.Tx.Rollback()
-			a.Tx = nil
+			j.cleanupJournal(entry)
 			panic(p)
 		}
-		a.Tx.Commit()
-		a.Tx = nil
 	}()
 
-	// thumbnails & files
-	var attachUIDs []string
-	attachUIDs = append(attachUIDs, a.restoreThumbnails(entry)...)
-	attachUIDs = append(attachUIDs, a.restoreFiles(entry)...)
+	for _, att := range attachments {
+		j.storeAttachmentFiles(att)
+	}
+
+	postUID := mustbe.OKVal(uuid.NewV4()).(uuid.UUID).String()
+
+	j.Tx = mustbe.OKVal(j.DB.Begin()).(*sql.Tx)
+	txStarted := time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			j.Tx.Rollback()
+			txDurationMetric.Observe(time.Since(txStarted).Seconds(), "rollback")
+			panic(p)
+		}
+		mustbe.OK(errors.Annotate(j.Tx.Commit(), "cannot commit transaction"))
+		txDurationMetric.Observe(time.Since(txStarted).Seconds(), "commit")
+		commentsMetric.Add(float64(j.report.VisibleComments), "visible")
+		commentsMetric.Add(float64(j.report.HiddenComments), "hidden")
+		likesMetric.Add(float64(j.report.VisibleLikes), "visible")
+		likesMetric.Add(float64(j.report.HiddenLikes), "hidden")
+		// The post and attachments are reported only if they are created
+		j.report.Status = statusRestored
+		j.report.Post = postUID
+		for _, att := range attachments {
+			j.report.Attachments = append(j.report.Attachments, att.UID)
+		}
+		// Now the stored images can be reused by other entries
+		j.Dedup.add(attachments)
+	}()
 
 	// create post
 	createdAt := entry.Date
@@ -51,8 +89,7 @@ func (a *App) restoreEntry(entry *clio.Entry) {
 		}
 	}
 
-	postUID := mustbe.OKVal(uuid.NewV4()).(uuid.UUID).String()
-	dbutil.MustInsert(a.Tx, "posts", dbutil.H{
+	dbutil.MustInsert(j.Tx, "posts", dbutil.H{
 		"uid":                  postUID,
 		"body":                 entry.Body,
 		"user_id":              entry.Author.UID,
@@ -63,70 +100,61 @@ func (a *App) restoreEntry(entry *clio.Entry) {
 		"destination_feed_ids": pq.Array([]int{entry.Author.Feeds.Posts.ID}),
 	})
 
-	infoLog.Println("created post with UID", postUID)
+	j.logWith("post", postUID)
+	j.infoLog.Println("created post with UID", postUID)
 
 	// register old post name
-	dbutil.MustInsert(a.Tx, "archive_post_names", dbutil.H{
+	dbutil.MustInsert(j.Tx, "archive_post_names", dbutil.H{
 		"post_id":       postUID,
 		"old_post_name": entry.Name,
 		"old_url":       entry.URL,
 	})
 
 	// register via
-	if viaID := a.getViaID(entry.Via); viaID != 0 {
-		dbutil.MustInsert(a.Tx, "archive_posts_via", dbutil.H{"via_id": viaID, "post_id": postUID})
+	if viaID := j.getViaID(entry.Via); viaID != 0 {
+		dbutil.MustInsert(j.Tx, "archive_posts_via", dbutil.H{"via_id": viaID, "post_id": postUID})
 	}
 
-	// post hashtags
+	// post hashtags (hashtags are created outside of the transaction
+	// because they are shared between the parallel jobs)
 	for _, h := range entry.Hashtags {
-		dbutil.MustInsertWithoutConflict(a.Tx, "hashtag_usages", dbutil.H{
-			"hashtag_id": hashtags.GetID(a.Tx, h),
+		dbutil.MustInsertWithoutConflict(j.Tx, "hashtag_usages", dbutil.H{
+			"hashtag_id": hashtags.GetID(j.DB, h),
 			"entity_id":  postUID,
 			"type":       "post",
 		})
 	}
 
-	// atach attachments
-	if len(attachUIDs) > 0 {
-		mustbe.OKVal(a.Tx.Exec(
-			`update attachments set 
-				post_id = $1,
-				user_id = $2,
-				created_at = $3,
-				updated_at = $4
-			where uid in (`+strings.Join(dbutil.QuoteStrings(attachUIDs), ",")+`)`,
-			postUID,
-			entry.Author.UID,
-			entry.Date,
-			entry.Date,
-		))
+	// attachments
+	for _, att := range attachments {
+		j.insertAttachment(att, postUID, entry)
 	}
 
-	a.incrementUserStat(entry.Author, statPosts)
+	j.incrementUserStat(entry.Author, statPosts)
 
 	// post feed_ids - all UIDs/IDs of post's feeds
 	feedIDs := make(map[string]int)
 	feedIDs[entry.Author.Feeds.Posts.UID] = entry.Author.Feeds.Posts.ID
 
 	// add comments
-	infoLog.Println("adding comments")
+	j.infoLog.Println("adding comments")
 	for _, c := range entry.Comments {
-		if a.commentPost(postUID, entry.Author, c) {
+		if j.commentPost(postUID, entry.Author, c) {
 			feedIDs[c.Author.Feeds.Comments.UID] = c.Author.Feeds.Comments.ID
-			a.incrementUserStat(c.Author, statComments)
+			j.incrementUserStat(c.Author, statComments)
 		}
 	}
 
 	// add likes
-	infoLog.Println("adding likes")
+	j.infoLog.Println("adding likes")
 	for _, l := range entry.Likes {
-		if a.likePost(postUID, l) {
+		if j.likePost(postUID, l) {
 			feedIDs[l.Author.Feeds.Likes.UID] = l.Author.Feeds.Likes.ID
-			a.incrementUserStat(l.Author, statLikes)
+			j.incrementUserStat(l.Author, statLikes)
 		}
 	}
 
-	infoLog.Println("updating feed_ids")
+	j.infoLog.Println("updating feed_ids")
 	{ // update post's feed_ids
 		var (
 			intIDs pq.Int64Array
@@ -140,15 +168,26 @@ func (a *App) restoreEntry(entry *clio.Entry) {
 			intIDs = append(intIDs, int64(intID))
 		}
 
-		mustbe.OKVal(a.Tx.Exec(`update posts set feed_ids = $1 where uid = $2`, intIDs, postUID))
+		mustbe.OKVal(j.Tx.Exec(`update posts set feed_ids = $1 where uid = $2`, intIDs, postUID))
 	}
+
+	j.updateUserStats()
 }
 
-func (a *App) likePost(postUID string, like *clio.Like) (restoredVisible bool) {
+func (j *restoreJob) likePost(postUID string, like *clio.Like) (restoredVisible bool) {
 	restoredVisible = like.Author.RestoreCommentsAndLikes
+	if restoredVisible {
+		j.report.VisibleLikes++
+	} else {
+		j.report.HiddenLikes++
+	}
+	if j.DryRun {
+		j.planLike(like, restoredVisible)
+		return
+	}
 	if restoredVisible {
 		// like is visible
-		dbutil.MustInsert(a.Tx, "likes", dbutil.H{
+		dbutil.MustInsert(j.Tx, "likes", dbutil.H{
 			"post_id":    postUID,
 			"user_id":    like.Author.UID,
 			"created_at": like.Date,
@@ -156,13 +195,13 @@ func (a *App) likePost(postUID string, like *clio.Like) (restoredVisible bool) {
 	} else {
 		// like is hidden
 		if like.Author.UID != "" {
-			dbutil.MustInsert(a.Tx, "hidden_likes", dbutil.H{
+			dbutil.MustInsert(j.Tx, "hidden_likes", dbutil.H{
 				"post_id": postUID,
 				"user_id": like.Author.UID,
 				"date":    like.Date,
 			})
 		} else {
-			dbutil.MustInsert(a.Tx, "hidden_likes", dbutil.H{
+			dbutil.MustInsert(j.Tx, "hidden_likes", dbutil.H{
 				"post_id":      postUID,
 				"old_username": like.Author.OldUserName,
 				"date":         like.Date,
@@ -172,13 +211,22 @@ func (a *App) likePost(postUID string, like *clio.Like) (restoredVisible bool) {
 	return
 }
 
-func (a *App) commentPost(postUID string, postAuthor *account.Account, comment *clio.Comment) (restoredVisible bool) {
-	commentID := mustbe.OKVal(uuid.NewV4()).(uuid.UUID).String()
+func (j *restoreJob) commentPost(postUID string, postAuthor *account.Account, comment *clio.Comment) (restoredVisible bool) {
 	restoredVisible = comment.Author.RestoreCommentsAndLikes ||
 		comment.Author.OldUserName == postAuthor.OldUserName
+	if restoredVisible {
+		j.report.VisibleComments++
+	} else {
+		j.report.HiddenComments++
+	}
+	if j.DryRun {
+		j.planComment(comment, restoredVisible)
+		return
+	}
+	commentID := mustbe.OKVal(uuid.NewV4()).(uuid.UUID).String()
 	if restoredVisible {
 		// comment is visible
-		dbutil.MustInsert(a.Tx, "comments", dbutil.H{
+		dbutil.MustInsert(j.Tx, "comments", dbutil.H{
 			"uid":        commentID,
 			"post_id":    postUID,
 			"body":       comment.Body,
@@ -190,8 +238,8 @@ func (a *App) commentPost(postUID string, postAuthor *account.Account, comment *
 
 		// comment hashtags
 		for _, h := range comment.Hashtags {
-			dbutil.MustInsertWithoutConflict(a.Tx, "hashtag_usages", dbutil.H{
-				"hashtag_id": hashtags.GetID(a.Tx, h),
+			dbutil.MustInsertWithoutConflict(j.Tx, "hashtag_usages", dbutil.H{
+				"hashtag_id": hashtags.GetID(j.DB, h),
 				"entity_id":  commentID,
 				"type":       "comment",
 			})
@@ -199,7 +247,7 @@ func (a *App) commentPost(postUID string, postAuthor *account.Account, comment *
 
 	} else {
 		// comment is hidden
-		dbutil.MustInsert(a.Tx, "comments", dbutil.H{
+		dbutil.MustInsert(j.Tx, "comments", dbutil.H{
 			"uid":        commentID,
 			"post_id":    postUID,
 			"body":       hiddenCommentBody,
@@ -210,13 +258,13 @@ func (a *App) commentPost(postUID string, postAuthor *account.Account, comment *
 		})
 
 		if comment.Author.UID != "" {
-			dbutil.MustInsert(a.Tx, "hidden_comments", dbutil.H{
+			dbutil.MustInsert(j.Tx, "hidden_comments", dbutil.H{
 				"comment_id": commentID,
 				"body":       comment.Body,
 				"user_id":    comment.Author.UID,
 			})
 		} else {
-			dbutil.MustInsert(a.Tx, "hidden_comments", dbutil.H{
+			dbutil.MustInsert(j.Tx, "hidden_comments", dbutil.H{
 				"comment_id":   commentID,
 				"body":         comment.Body,
 				"old_username": comment.Author.OldUserName,
@@ -226,10 +274,38 @@ func (a *App) commentPost(postUID string, postAuthor *account.Account, comment *
 	return
 }
 
-func (a *App) incrementUserStat(acc *account.Account, t statType) {
-	colName := pq.QuoteIdentifier(string(t) + "_count")
-	mustbe.OKVal(a.Tx.Exec(
-		`update user_stats set `+colName+` = `+colName+` + 1 where user_id = $1`,
-		acc.UID,
-	))
+// userStats is a set of user_stats increments: user UID -> stat type -> delta
+type userStats map[string]map[statType]int
+
+func (j *restoreJob) incrementUserStat(acc *account.Account, t statType) {
+	if j.userStats == nil {
+		j.userStats = make(userStats)
+	}
+	if j.userStats[acc.UID] == nil {
+		j.userStats[acc.UID] = make(map[statType]int)
+	}
+	j.userStats[acc.UID][t]++
+}
+
+// updateUserStats writes the collected user_stats increments. It is called
+// at the end of transaction and updates users in the fixed order, so parallel
+// transactions hold these rows' locks for a short time and cannot deadlock.
+func (j *restoreJob) updateUserStats() {
+	var uids []string
+	for uid := range j.userStats {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	for _, uid := range uids {
+		for _, t := range []statType{statPosts, statComments, statLikes} {
+			if n := j.userStats[uid][t]; n > 0 {
+				colName := pq.QuoteIdentifier(string(t) + "_count")
+				mustbe.OKVal(j.Tx.Exec(
+					`update user_stats set `+colName+` = `+colName+` + $1 where user_id = $2`,
+					n, uid,
+				))
+			}
+		}
+	}
 }
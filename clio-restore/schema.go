@@ -0,0 +1,34 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/davidmz/mustbe"
+)
+
+// schemaFeatures are the optional features of the FreeFeed DB schema. The
+// legacy schema has only the image, audio and general media types and no
+// duration column.
+type schemaFeatures struct {
+	Video    bool // attachments.media_type accepts 'video'
+	Duration bool // attachments.duration column exists
+}
+
+func readSchemaFeatures(db *sql.DB) (f schemaFeatures) {
+	mustbe.OK(db.QueryRow(
+		`select exists(
+			select 1 from pg_enum e
+				join pg_type t on t.oid = e.enumtypid
+				join information_schema.columns c on c.udt_name = t.typname
+			where c.table_name = 'attachments' and c.column_name = 'media_type'
+				and e.enumlabel = 'video'
+		)`,
+	).Scan(&f.Video))
+	mustbe.OK(db.QueryRow(
+		`select exists(
+			select 1 from information_schema.columns
+			where table_name = 'attachments' and column_name = 'duration'
+		)`,
+	).Scan(&f.Duration))
+	return
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/FreeFeed/clio-restore/internal/archive"
+	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/config"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/davidmz/mustbe"
+	"github.com/juju/errors"
+)
+
+// Spool subdirectories
+const (
+	spoolDoneDir   = "done"
+	spoolFailedDir = "failed"
+	spoolLogsDir   = "logs"
+)
+
+// spoolSettleTime is the time since the last modification after which the
+// uploaded archive is considered complete
+const spoolSettleTime = time.Minute
+
+// spool restores archives from the spool directory. Every archive is moved
+// to the 'done' or 'failed' subdirectory after the restoration, its log is
+// written to 'logs/ARCHIVE.log'. Archives whose owners have not started the
+// restoration yet are left in place.
+type spool struct {
+	Dir  string
+	Conf *config.Config
+	App  App // template of App for every archive
+
+	db      *sql.DB
+	waiting map[string]bool // archives that are already reported as waiting
+}
+
+// Init creates the spool subdirectories and connects to DB
+func (s *spool) Init() {
+	for _, d := range []string{spoolDoneDir, spoolFailedDir, spoolLogsDir} {
+		mustbe.OK(errors.Annotate(os.MkdirAll(filepath.Join(s.Dir, d), 0755), "cannot create spool directory"))
+	}
+
+	var err error
+	s.db, err = sql.Open("postgres", s.Conf.DbStr)
+	mustbe.OK(errors.Annotate(err, "cannot open DB"))
+	mustbe.OK(errors.Annotate(s.db.Ping(), "cannot connect to DB"))
+
+	s.waiting = make(map[string]bool)
+}
+
+// Process restores all archives that are in the spool directory now
+func (s *spool) Process() {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		errorLog.Println("Cannot read spool directory:", err)
+		return
+	}
+
+	for _, fi := range infos {
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") ||
+			name == spoolDoneDir || name == spoolFailedDir || name == spoolLogsDir ||
+			time.Since(fi.ModTime()) < spoolSettleTime {
+			continue
+		}
+		s.processArchive(name)
+	}
+}
+
+func (s *spool) processArchive(name string) {
+	archFile := filepath.Join(s.Dir, name)
+
+	// Archive is opened once for the status check and the restoration
+	// (tar.gz is unpacked on open)
+	var (
+		owner  string
+		status int
+	)
+	arch, err := archive.Open(archFile)
+	if err != nil {
+		err = errors.Annotate(err, "cannot open archive file")
+	} else {
+		defer func() {
+			if arch != nil {
+				arch.Close()
+			}
+		}()
+		owner, status, err = s.archiveStatus(arch)
+	}
+	if _, ok := err.(dbError); ok {
+		// DB may be temporarily unavailable, try on the next poll
+		errorLog.Printf("Cannot check archive %s, will retry: %v", name, err)
+		return
+	}
+	if err == nil && status == recoveryNotStarted {
+		if !s.waiting[name] {
+			infoLog.Printf("Archive %s of %s is waiting for the owner's request", name, owner)
+			s.waiting[name] = true
+		}
+		return
+	}
+	delete(s.waiting, name)
+
+	logFile, lErr := os.OpenFile(
+		filepath.Join(s.Dir, spoolLogsDir, name+".log"),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644,
+	)
+	if lErr != nil {
+		errorLog.Printf("Cannot create log file of %s: %v", name, lErr)
+		return
+	}
+	defer logFile.Close()
+
+	logging.Tee(logFile)
+	switch {
+	case err != nil:
+	case status == recoveryFinished:
+		err = errors.Errorf("archive of %s already restored", owner)
+	default:
+		infoLog.Printf("Restoring archive %s of %s", name, owner)
+		err = s.restore(archFile, arch)
+	}
+	targetDir := spoolDoneDir
+	if err != nil {
+		targetDir = spoolFailedDir
+		errorLog.Printf("Archive %s failed: %v", name, err)
+	} else {
+		infoLog.Printf("Archive %s restored", name)
+	}
+	logging.Tee(nil)
+	logging.SetContext()
+	if arch != nil {
+		arch.Close()
+		arch = nil
+	}
+
+	target := filepath.Join(s.Dir, targetDir, name)
+	if _, err := os.Stat(target); err == nil {
+		target += "." + time.Now().Format("20060102150405")
+	}
+	if err := os.Rename(archFile, target); err != nil {
+		errorLog.Printf("Cannot move %s to %s: %v", name, targetDir, err)
+	}
+}
+
+// archiveStatus returns the archive owner name and the recovery_status of
+// the archive
+func (s *spool) archiveStatus(arch *archive.Archive) (owner string, status int, err error) {
+	name, ok := arch.Find(feedInfoRe)
+	if !ok {
+		return "", 0, errors.New("cannot find feedinfo.js")
+	}
+	user := new(clio.UserJSON)
+	if err := arch.ReadJSON(name, user); err != nil {
+		return "", 0, errors.Annotate(err, "cannot read feed info")
+	}
+
+	err = s.db.QueryRow(
+		"select recovery_status from archives where old_username = $1", user.UserName,
+	).Scan(&status)
+	if err == sql.ErrNoRows {
+		return user.UserName, 0, errors.Errorf("cannot find archive record of %s", user.UserName)
+	}
+	if err != nil {
+		return user.UserName, 0, dbError{errors.Annotate(err, "cannot get archive status")}
+	}
+	return user.UserName, status, nil
+}
+
+// dbError is an error of the DB query, unlike the archive errors it is
+// temporary
+type dbError struct{ error }
+
+// restore restores a single archive catching all panics
+func (s *spool) restore(archFile string, arch *archive.Archive) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+			logging.Output().Write(debug.Stack())
+		}
+	}()
+	defer mustbe.Catched(func(e error) { err = e })
+
+	app := s.App
+	app.ReportFile = filepath.Join(s.Dir, spoolLogsDir, filepath.Base(archFile)+".report.json")
+	restoreArchive(archFile, arch, s.Conf, &app)
+	return nil
+}
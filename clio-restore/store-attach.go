@@ -1,53 +1,54 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
-	"io/ioutil"
-	"net/url"
-	"os"
-	"path/filepath"
-	"regexp"
-	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/orphans"
 	"github.com/davidmz/mustbe"
 )
 
-func (a *App) storeAttachment(body []byte, path, name, contentType string) {
-	if a.AttDir != "" {
-		// Save to disk
-		fileName := filepath.Join(a.AttDir, path)
-		mustbe.OK(os.MkdirAll(filepath.Dir(fileName), 0777))
-		mustbe.OK(ioutil.WriteFile(fileName, body, 0666))
-	} else {
-		// Upload to S3
-		mustbe.OKVal(a.S3Client.PutObject(
-			new(s3.PutObjectInput).
-				SetBody(bytes.NewReader(body)).
-				SetBucket(a.S3Bucket).
-				SetKey(path).
-				SetContentType(contentType).
-				SetContentLength(int64(len(body))).
-				SetACL(s3.ObjectCannedACLPublicRead).
-				SetContentDisposition(contentDispositionString("inline", name)),
-		))
+func (j *restoreJob) storeAttachment(body []byte, path, name, contentType string) {
+	mustbe.OK(j.Storage.Put(path, body, contentType, name))
+	j.journal = append(j.journal, path)
+	storedFilesMetric.Inc("upload")
+	uploadedBytesMetric.Add(float64(len(body)))
+}
+
+// copyAttachment copies the stored file. If storage cannot copy it, the file
+// is read and uploaded.
+func (j *restoreJob) copyAttachment(srcPath, path, name, contentType string) {
+	err := j.Storage.Copy(srcPath, path, contentType, name)
+	if err == nil {
+		j.journal = append(j.journal, path)
+		storedFilesMetric.Inc("copy")
+		return
 	}
+	j.errorLog.Printf("Cannot copy stored file %s, uploading it: %v", srcPath, err)
+	body := mustbe.OKVal(j.Storage.Read(srcPath)).([]byte)
+	j.storeAttachment(body, path, name, contentType)
 }
 
-var nonASCIIRe = regexp.MustCompile(`[^\x20-\x7f]`)
+// cleanupJournal deletes all files stored during the current entry
+// restoration. Files that cannot be deleted are written to the orphans file.
+func (j *restoreJob) cleanupJournal(entry *clio.Entry) {
+	var failed []orphans.Record
+	for _, path := range j.journal {
+		if err := j.Storage.Delete(path); err != nil {
+			j.errorLog.Printf("Cannot delete stored file %s: %v", path, err)
+			failed = append(failed, orphans.Record{Key: path, Date: time.Now(), Comment: entry.Name})
+		}
+	}
+	j.journal = nil
 
-// Get cross-browser Content-Disposition header for attachment
-func contentDispositionString(disposition, name string) string {
-	if name == "" {
-		return disposition
+	if len(failed) > 0 {
+		if err := orphans.Append(j.OrphansFile, failed...); err != nil {
+			j.errorLog.Printf("Cannot save orphaned files list: %v", err)
+			for _, r := range failed {
+				j.errorLog.Println("Orphaned file:", r.Key)
+			}
+		} else {
+			j.errorLog.Printf("%d orphaned files were written to %s", len(failed), j.OrphansFile)
+		}
 	}
-	// Old browsers (IE8) need ASCII-only fallback filenames
-	fileNameASCII := nonASCIIRe.ReplaceAllString(name, "_")
-	// Modern browsers support UTF-8 filenames
-	fileNameUTF8 := url.QueryEscape(name)
-	// Go's QueryEscape replace spaces to '+', not '%20'
-	fileNameUTF8 = strings.Replace(fileNameUTF8, "+", "%20", -1)
-	// Inline version of 'attfnboth' method (http://greenbytes.de/tech/tc2231/#attfnboth)
-	return fmt.Sprintf(`%s; filename="%s"; filename*=utf-8''%s`, disposition, fileNameASCII, fileNameUTF8)
 }
@@ -2,20 +2,32 @@ package main
 
 import (
 	"database/sql"
+	"sync"
 
 	"github.com/FreeFeed/clio-restore/internal/clio"
 	"github.com/davidmz/mustbe"
 )
 
-var viaCache = make(map[string]int)
+// viaCache holds IDs of the via-records of the current run
+type viaCache struct {
+	mu  sync.Mutex
+	ids map[string]int // via URL -> ID
+}
+
+func newViaCache() *viaCache { return &viaCache{ids: make(map[string]int)} }
 
-// getViaID returns ID of via-record or 0 if entry has really not 'via'
+// getViaID returns ID of via-record or 0 if entry has really not 'via'.
+// Via-records are created outside of the entry transaction because
+// they are shared between the parallel jobs.
 func (a *App) getViaID(via clio.ViaJSON) int {
 	if via.URL == clio.DefaultViaURL {
 		return 0
 	}
 
-	if id, ok := viaCache[via.URL]; ok {
+	a.vias.mu.Lock()
+	defer a.vias.mu.Unlock()
+
+	if id, ok := a.vias.ids[via.URL]; ok {
 		return id
 	}
 
@@ -24,15 +36,15 @@ func (a *App) getViaID(via clio.ViaJSON) int {
 		err = sql.ErrNoRows
 	)
 	for err != nil {
-		err = mustbe.OKOr(a.Tx.QueryRow(`select id from archive_via where url = $1`, via.URL).Scan(&id), sql.ErrNoRows)
+		err = mustbe.OKOr(a.DB.QueryRow(`select id from archive_via where url = $1`, via.URL).Scan(&id), sql.ErrNoRows)
 		if err != nil { // row not found
-			err = mustbe.OKOr(a.Tx.QueryRow(
+			err = mustbe.OKOr(a.DB.QueryRow(
 				`insert into archive_via (url, title) values ($1, $2) returning id`,
 				via.URL, via.Name,
 			).Scan(&id), sql.ErrNoRows)
 		}
 	}
 
-	viaCache[via.URL] = id
+	a.vias.ids[via.URL] = id
 	return id
 }
@@ -4,13 +4,13 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"runtime/debug"
 	"time"
 
 	"github.com/FreeFeed/clio-restore/internal/config"
 	"github.com/FreeFeed/clio-restore/internal/dbutil"
+	"github.com/FreeFeed/clio-restore/internal/logging"
 	"github.com/davidmz/mustbe"
 	_ "github.com/lib/pq"
 )
@@ -22,9 +22,9 @@ const (
 
 // Globals
 var (
-	infoLog  = log.New(os.Stdout, "INFO  ", log.LstdFlags)
-	errorLog = log.New(os.Stdout, "ERROR ", log.LstdFlags)
-	fatalLog = log.New(os.Stdout, "FATAL ", log.LstdFlags)
+	infoLog  = logging.Info
+	errorLog = logging.Error
+	fatalLog = logging.Fatal
 )
 
 const dateFormat = "2006-01-02"
@@ -44,6 +44,9 @@ func main() {
 	flag.BoolVar(&removeFromOwnPosts, "from-own-posts", false, "remove user's comments from their own posts")
 	flag.Parse()
 
+	mustbe.OK(logging.Setup("clio-rollback-activities", os.Stdout))
+	defer logging.Close()
+
 	if flag.Arg(0) == "" {
 		fmt.Fprintln(os.Stderr, "Usage: clio-rollback-activities [options] username")
 		flag.PrintDefaults()
@@ -59,6 +62,8 @@ func main() {
 		userID   string
 	)
 
+	logging.SetContext("newOwner", username)
+
 	db = mustbe.OKVal(sql.Open("postgres", conf.DbStr)).(*sql.DB)
 	mustbe.OK(db.Ping())
 
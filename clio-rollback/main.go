@@ -2,29 +2,28 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path"
-	"path/filepath"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/FreeFeed/clio-restore/internal/config"
 	"github.com/FreeFeed/clio-restore/internal/dbutil"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/FreeFeed/clio-restore/internal/storage"
 	"github.com/davidmz/mustbe"
-	"github.com/juju/errors"
 	_ "github.com/lib/pq"
 )
 
 // Globals
 var (
-	infoLog  = log.New(os.Stdout, "INFO  ", log.LstdFlags)
-	errorLog = log.New(os.Stdout, "ERROR ", log.LstdFlags)
-	fatalLog = log.New(os.Stdout, "FATAL ", log.LstdFlags)
+	infoLog  = logging.Info
+	errorLog = logging.Error
+	fatalLog = logging.Fatal
 )
 
 const dateFormat = "2006-01-02"
@@ -32,6 +31,7 @@ const dateFormat = "2006-01-02"
 func main() {
 	var (
 		cutDateString string
+		sweep         bool
 	)
 
 	defer mustbe.Catched(func(err error) {
@@ -40,24 +40,36 @@ func main() {
 	})
 
 	flag.StringVar(&cutDateString, "before", "2015-05-01", "delete records before this date")
+	flag.BoolVar(&sweep, "sweep-orphans", false, "delete orphaned files left by failed clio-restore runs (username is not required)")
 	flag.Parse()
 
-	if flag.Arg(0) == "" {
-		fmt.Fprintln(os.Stderr, "Usage: clio-rollback [options] username")
+	mustbe.OK(logging.Setup("clio-rollback", os.Stdout))
+	defer logging.Close()
+
+	if flag.Arg(0) == "" && !sweep {
+		fmt.Fprintln(os.Stderr, "Usage: clio-rollback [options] username\n       clio-rollback [options] -sweep-orphans")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
 	conf := mustbe.OKVal(config.Load()).(*config.Config)
 
+	store := mustbe.OKVal(storage.New(conf)).(storage.Storage)
+
+	if sweep {
+		sweepOrphans(conf, store)
+		return
+	}
+
 	var (
 		username = flag.Arg(0)
 		cutDate  = mustbe.OKVal(time.Parse(dateFormat, cutDateString)).(time.Time)
 		db       *sql.DB
-		s3Client *s3.S3
 		userID   string
 	)
 
+	logging.SetContext("newOwner", username)
+
 	db = mustbe.OKVal(sql.Open("postgres", conf.DbStr)).(*sql.DB)
 	mustbe.OK(db.Ping())
 
@@ -67,12 +79,6 @@ func main() {
 		fatalLog.Fatalf("Cannot find user '%s'", username)
 	}
 
-	if conf.S3Bucket != "" {
-		awsSession, err := session.NewSession()
-		mustbe.OK(errors.Annotate(err, "cannot create AWS session"))
-		s3Client = s3.New(awsSession)
-	}
-
 	infoLog.Printf("Trying to delete all %s's posts and files created before %s", username, cutDate.Format(dateFormat))
 
 	var postIDs []string
@@ -139,45 +145,16 @@ func main() {
 
 	infoLog.Print("All posts was processed")
 
-	var attachments []struct {
-		ID        string
-		Ext       string
-		HasThumbs bool
-	}
+	var attachments []storedAttachment
 	mustbe.OK(dbutil.QueryCols(
 		db, &attachments,
-		"select uid, file_extension, not no_thumbnail from attachments where user_id = $1 and created_at < $2",
+		"select uid, file_extension, not no_thumbnail, image_sizes from attachments where user_id = $1 and created_at < $2",
 		userID, cutDate,
 	))
 
 	infoLog.Printf("Found %d files", len(attachments))
 	for n, att := range attachments {
-		name := att.ID + "." + att.Ext
-		fileNames := []string{path.Join("attachments", name)}
-		if att.HasThumbs {
-			fileNames = append(fileNames, path.Join("attachments", "thumbnails", name))
-			fileNames = append(fileNames, path.Join("attachments", "thumbnails2", name))
-		}
-
-		if conf.AttDir != "" {
-			for _, fileName := range fileNames {
-				if err := os.Remove(filepath.Join(conf.AttDir, fileName)); os.IsNotExist(err) {
-					errorLog.Println("File not found:", fileName)
-				} else {
-					mustbe.OK(err)
-				}
-			}
-		} else {
-			del := new(s3.Delete)
-			for _, fileName := range fileNames {
-				del.Objects = append(del.Objects, new(s3.ObjectIdentifier).SetKey(fileName))
-			}
-			mustbe.OKVal(s3Client.DeleteObjects(
-				new(s3.DeleteObjectsInput).
-					SetBucket(conf.S3Bucket).
-					SetDelete(del),
-			))
-		}
+		mustbe.OK(store.Delete(att.fileNames(conf)...))
 
 		mustbe.OKVal(db.Exec("delete from attachments where uid = $1", att.ID))
 
@@ -195,3 +172,58 @@ func main() {
 
 	infoLog.Printf("recovery_status resetted to %d", 1)
 }
+
+type storedAttachment struct {
+	ID         string
+	Ext        string
+	HasThumbs  bool
+	ImageSizes []byte // image_sizes JSON, nil for the non-image files
+}
+
+// fileNames returns the storage paths of the attachment files. Paths of images
+// are taken from the URLs in image_sizes, so the files written with other
+// size presets are deleted too. Other files are in the original size directory.
+func (att *storedAttachment) fileNames(conf *config.Config) []string {
+	name := att.ID
+	if att.Ext != "" { // files of unknown type have no extension
+		name += "." + att.Ext
+	}
+
+	var sizes map[string]struct {
+		URL string            `json:"url"`
+		Alt map[string]string `json:"alt"`
+	}
+	if att.ImageSizes != nil {
+		if err := json.Unmarshal(att.ImageSizes, &sizes); err != nil {
+			errorLog.Printf("Cannot parse image_sizes of %s: %v", att.ID, err)
+		}
+	}
+
+	var fileNames []string
+	if len(sizes) == 0 {
+		// Images restored before the image_sizes were filled
+		for szID, preset := range conf.ImageSizes {
+			if szID != config.OriginalSize && !att.HasThumbs {
+				continue
+			}
+			fileNames = append(fileNames, path.Join(preset.Dir, name))
+		}
+		return fileNames
+	}
+
+	prefix := conf.AttURL + "/"
+	addURL := func(u string) {
+		if !strings.HasPrefix(u, prefix) {
+			errorLog.Printf("Cannot find storage path of %s: URL %q is not in %s", att.ID, u, conf.AttURL)
+			return
+		}
+		fileNames = append(fileNames, strings.TrimPrefix(u, prefix))
+	}
+	for _, sz := range sizes {
+		addURL(sz.URL)
+		for _, u := range sz.Alt {
+			addURL(u)
+		}
+	}
+	return fileNames
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/FreeFeed/clio-restore/internal/config"
+	"github.com/FreeFeed/clio-restore/internal/orphans"
+	"github.com/FreeFeed/clio-restore/internal/storage"
+	"github.com/davidmz/mustbe"
+)
+
+// sweepOrphans deletes files listed in the orphans file (files stored by
+// clio-restore during the failed entries restoration). Files that still
+// cannot be deleted are kept in the list.
+func sweepOrphans(conf *config.Config, store storage.Storage) {
+	recs := mustbe.OKVal(orphans.Read(conf.OrphansFile)).([]orphans.Record)
+
+	infoLog.Printf("Found %d orphaned files in %s", len(recs), conf.OrphansFile)
+
+	var failed []orphans.Record
+	for _, r := range recs {
+		if err := store.Delete(r.Key); err != nil {
+			errorLog.Printf("Cannot delete %s: %v", r.Key, err)
+			failed = append(failed, r)
+		}
+	}
+
+	mustbe.OK(orphans.Write(conf.OrphansFile, failed))
+
+	infoLog.Printf("%d files was deleted, %d remain in list", len(recs)-len(failed), len(failed))
+}
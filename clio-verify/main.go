@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+
+	"github.com/FreeFeed/clio-restore/internal/archive"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/davidmz/mustbe"
+)
+
+// Globals
+var (
+	fatalLog = logging.Fatal
+)
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitUsage   = 1
+	exitCorrupt = 2
+)
+
+func main() {
+	defer mustbe.Catched(func(err error) {
+		fatalLog.Println(err)
+		debug.PrintStack()
+		os.Exit(exitCorrupt)
+	})
+
+	var strict bool
+
+	flag.BoolVar(&strict, "strict", false, "treat entries with the missing local media as corruption")
+	flag.Parse()
+
+	mustbe.OK(logging.Setup("clio-verify", os.Stderr))
+	defer logging.Close()
+
+	if flag.Arg(0) == "" {
+		fmt.Fprintln(os.Stderr, "Usage: clio-verify [options] clio-archive")
+		flag.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	rep := &report{Archive: flag.Arg(0)}
+	if arch, err := archive.Open(flag.Arg(0)); err != nil {
+		rep.addError("", "cannot open archive file: %v", err)
+	} else {
+		verifyArchive(arch, rep)
+		arch.Close()
+	}
+	rep.OK = len(rep.Errors) == 0 && (!strict || len(rep.MissingMedia) == 0)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	mustbe.OK(enc.Encode(rep))
+
+	if !rep.OK {
+		os.Exit(exitCorrupt)
+	}
+	os.Exit(exitOK)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/FreeFeed/clio-restore/internal/archive"
+	"github.com/FreeFeed/clio-restore/internal/clio"
+	"github.com/FreeFeed/clio-restore/internal/thumbs"
+)
+
+var (
+	feedInfoRe   = regexp.MustCompile(`^[a-z0-9-]+/_json/data/feedinfo\.js$`)
+	entryRe      = regexp.MustCompile(`^[a-z0-9-]+/_json/data/entries/[0-9a-f]{8}\.js$`)
+	imagesTSVRe  = regexp.MustCompile(`^[a-z0-9-]+/_json/data/images\.tsv$`)
+	filesTSVRe   = regexp.MustCompile(`^[a-z0-9-]+/_json/data/files\.tsv$`)
+	thumbFileRe  = regexp.MustCompile(`^[a-z0-9-]+/images/media/thumbnails/([0-9a-f]+)[^/]+$`)
+	mediaIDRe    = regexp.MustCompile(`[0-9a-f]+$`)
+	ffMediaURLRe = thumbs.FFMediaURLRe
+)
+
+// maxListedMiss is the max number of the listed missing media items
+const maxListedMiss = 1000
+
+// report is the verification result
+type report struct {
+	Archive      string          `json:"archive"`
+	OK           bool            `json:"ok"`
+	Owner        string          `json:"owner,omitempty"`
+	Files        int             `json:"files"`      // files in archive
+	Entries      int             `json:"entries"`    // valid entries
+	Images       int             `json:"images"`     // images listed in images.tsv
+	OtherFiles   int             `json:"otherFiles"` // files listed in files.tsv
+	Errors       []problem       `json:"errors"`
+	MissingMedia []missingMedia  `json:"missingMedia"`
+	MissingCount map[string]int  `json:"missingCount"` // kind -> count
+	listed       map[string]bool // for the missingMedia deduplication
+}
+
+type problem struct {
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+type missingMedia struct {
+	Entry string `json:"entry"`
+	Kind  string `json:"kind"` // "image" or "file"
+	URL   string `json:"url"`
+}
+
+func (r *report) addError(file, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, problem{File: file, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *report) addMissing(entry, kind, URL string) {
+	if r.MissingCount == nil {
+		r.MissingCount = make(map[string]int)
+		r.listed = make(map[string]bool)
+	}
+	r.MissingCount[kind]++
+	if key := entry + " " + URL; !r.listed[key] && len(r.MissingMedia) < maxListedMiss {
+		r.listed[key] = true
+		r.MissingMedia = append(r.MissingMedia, missingMedia{Entry: entry, Kind: kind, URL: URL})
+	}
+}
+
+func verifyArchive(arch *archive.Archive, rep *report) {
+	rep.Errors = []problem{}
+	rep.MissingMedia = []missingMedia{}
+	rep.Files = len(arch.Names)
+
+	// Check the archive integrity: read all files (zip reader checks CRC-32)
+	byName := make(map[string]bool)
+	for _, name := range arch.Names {
+		byName[name] = true
+		if err := readAll(arch, name, ioutil.Discard); err != nil {
+			rep.addError(name, "cannot read file: %v", err)
+		}
+	}
+
+	// Feed info
+	if name, ok := arch.Find(feedInfoRe); !ok {
+		rep.addError("", "feedinfo.js not found")
+	} else {
+		user := new(clio.UserJSON)
+		if err := arch.ReadJSON(name, user); err != nil {
+			rep.addError(name, "cannot parse feed info: %v", err)
+		} else if user.Type != "user" {
+			rep.addError(name, "@%s is not a user (%s)", user.UserName, user.Type)
+		} else {
+			rep.Owner = user.UserName
+		}
+	}
+
+	// Media indexes
+	imageIDs := readTSV(arch, byName, imagesTSVRe, "images/media", rep)
+	rep.Images = len(imageIDs)
+	for _, name := range arch.Names {
+		if m := thumbFileRe.FindStringSubmatch(name); m != nil {
+			imageIDs[m[1]] = true
+		}
+	}
+	fileIDs := readTSV(arch, byName, filesTSVRe, "files", rep)
+	rep.OtherFiles = len(fileIDs)
+
+	// Entries
+	for _, name := range arch.Names {
+		if !entryRe.MatchString(name) {
+			continue
+		}
+		entry := new(clio.Entry)
+		if err := arch.ReadJSON(name, entry); err != nil {
+			rep.addError(name, "cannot parse entry: %v", err)
+			continue
+		}
+		rep.Entries++
+
+		for _, t := range entry.Thumbnails {
+			for _, u := range []string{t.URL, t.Link} {
+				if m := ffMediaURLRe.FindStringSubmatch(u); m != nil && !imageIDs[m[1]] {
+					rep.addMissing(entry.Name, "image", u)
+				}
+			}
+		}
+		for _, af := range entry.Files {
+			if id := mediaIDRe.FindString(af.URL); id == "" || !fileIDs[id] {
+				rep.addMissing(entry.Name, "file", af.URL)
+			}
+		}
+	}
+}
+
+// readTSV reads images.tsv or files.tsv and checks that the listed files
+// exist in dir. It returns the set of the media IDs.
+func readTSV(arch *archive.Archive, byName map[string]bool, re *regexp.Regexp, dir string, rep *report) map[string]bool {
+	ids := make(map[string]bool)
+	name, ok := arch.Find(re)
+	if !ok {
+		return ids
+	}
+	root := strings.SplitN(name, "/", 2)[0]
+
+	r, err := arch.Open(name)
+	if err != nil {
+		rep.addError(name, "cannot open file: %v", err)
+		return ids
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if scanner.Text() == "" {
+			continue
+		}
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		id := mediaIDRe.FindString(parts[0])
+		if len(parts) != 2 || id == "" {
+			rep.addError(name, "line %d: invalid format", line)
+			continue
+		}
+		if !byName[path.Join(root, dir, parts[1])] {
+			rep.addError(name, "line %d: file %s not found", line, path.Join(dir, parts[1]))
+			continue
+		}
+		ids[id] = true
+	}
+	if err := scanner.Err(); err != nil {
+		rep.addError(name, "cannot read file: %v", err)
+	}
+	return ids
+}
+
+func readAll(arch *archive.Archive, name string, w io.Writer) error {
+	r, err := arch.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
@@ -0,0 +1,3 @@
+module github.com/FreeFeed/clio-restore
+
+go 1.21
@@ -2,6 +2,7 @@ package account
 
 import (
 	"database/sql"
+	"sync"
 
 	"github.com/davidmz/mustbe"
 )
@@ -32,9 +33,10 @@ func (a *Account) IsExists() bool {
 	return a.UID != ""
 }
 
-// Store is a db fetcher and cache of accounts
+// Store is a db fetcher and cache of accounts. Store is safe for concurrent use.
 type Store struct {
 	db    *sql.DB
+	mu    sync.Mutex
 	cache map[string]*Account
 }
 
@@ -49,6 +51,9 @@ func NewStore(db *sql.DB) *Store {
 // Get returns Account by old user's username. Get always returns not-nil value
 // even if account does not exists in DB.
 func (s *Store) Get(oldUserName string) *Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if a, ok := s.cache[oldUserName]; ok {
 		return a
 	}
@@ -0,0 +1,209 @@
+// Package archive provides read access to the Clio archives. Archive can be
+// a zip file, an unpacked directory or a tar.gz file.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Archive is an opened archive. File names are slash-separated paths
+// relative to the archive root, like "username/_json/data/feedinfo.js".
+type Archive struct {
+	fs.FS
+	Names []string // names of all regular files in the archive order
+
+	close func() error
+}
+
+// Open opens archive. The archive type is detected by its content.
+func Open(name string) (*Archive, error) {
+	st, err := os.Stat(name)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot open archive")
+	}
+	if st.IsDir() {
+		return openDir(name)
+	}
+
+	header := make([]byte, 4)
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot open archive")
+	}
+	_, err = io.ReadFull(f, header)
+	f.Close()
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot read archive")
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK")):
+		return openZip(name)
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return openTarGz(name)
+	}
+	return nil, errors.Errorf("unknown archive format of %s", name)
+}
+
+// Close closes archive and releases its resources
+func (a *Archive) Close() error {
+	if a.close == nil {
+		return nil
+	}
+	return a.close()
+}
+
+// Find returns the name of the first file matching re
+func (a *Archive) Find(re *regexp.Regexp) (name string, ok bool) {
+	for _, n := range a.Names {
+		if re.MatchString(n) {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// ReadJSON reads and parses JSON file
+func (a *Archive) ReadJSON(name string, v interface{}) error {
+	data, err := a.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.Annotate(err, "cannot parse JSON")
+	}
+	return nil
+}
+
+// ReadFile reads the whole file
+func (a *Archive) ReadFile(name string) ([]byte, error) {
+	data, err := fs.ReadFile(a.FS, name)
+	return data, errors.Annotate(err, "cannot read archived file")
+}
+
+// Size returns the file size
+func (a *Archive) Size(name string) (int64, error) {
+	st, err := fs.Stat(a.FS, name)
+	if err != nil {
+		return 0, errors.Annotate(err, "cannot stat archived file")
+	}
+	return st.Size(), nil
+}
+
+func openZip(name string) (*Archive, error) {
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot open zip archive")
+	}
+	a := &Archive{FS: &r.Reader, close: r.Close}
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() {
+			a.Names = append(a.Names, f.Name)
+		}
+	}
+	return a, nil
+}
+
+// openDir opens the unpacked archive. Dir is the parent of the username
+// directory, as the root of the zip archive.
+func openDir(dir string) (*Archive, error) {
+	if st, err := os.Stat(filepath.Join(dir, "_json")); err == nil && st.IsDir() {
+		return nil, errors.Errorf("%s is the user directory, the archive directory is its parent", dir)
+	}
+	a := &Archive{FS: os.DirFS(dir)}
+	err := fs.WalkDir(a.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			a.Names = append(a.Names, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot read archive directory")
+	}
+	return a, nil
+}
+
+// openTarGz unpacks tar.gz archive to the temporary directory, that is
+// removed on Close. Tar files cannot be read randomly, so it is the only
+// practical way to read entries and media in arbitrary order.
+func openTarGz(name string) (a *Archive, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot open archive")
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot open tar.gz archive")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "clio-archive-")
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot create temporary directory")
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmpDir)
+		}
+	}()
+	a = &Archive{
+		FS:    os.DirFS(tmpDir),
+		close: func() error { return os.RemoveAll(tmpDir) },
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Annotate(err, "cannot read tar.gz archive")
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		p := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if !fs.ValidPath(p) {
+			return nil, errors.Errorf("invalid file name in tar.gz archive: %q", hdr.Name)
+		}
+		if err := extractFile(filepath.Join(tmpDir, filepath.FromSlash(p)), tr); err != nil {
+			return nil, errors.Annotatef(err, "cannot extract %s", hdr.Name)
+		}
+		a.Names = append(a.Names, p)
+	}
+	return a, nil
+}
+
+func extractFile(fileName string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,164 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var testFiles = []struct {
+	Name, Body string
+}{
+	{"user/_json/data/feedinfo.js", `{"id":"user","type":"user"}`},
+	{"user/_json/data/entries/0123abcd.js", `{"name":"e1"}`},
+	{"user/images/media/pic.jpg", "image"},
+}
+
+func writeZip(t *testing.T, fileName string) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	if _, err := w.Create("user/"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tf := range testFiles {
+		fw, err := w.Create(tf.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(tf.Body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGz(t *testing.T, fileName string, names ...string) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	w := tar.NewWriter(gz)
+	w.WriteHeader(&tar.Header{Name: "./user/", Typeflag: tar.TypeDir, Mode: 0755})
+	for i, tf := range testFiles {
+		name := "./" + tf.Name
+		if i < len(names) {
+			name = names[i]
+		}
+		w.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(tf.Body))})
+		w.Write([]byte(tf.Body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeDir(t *testing.T, dir string) {
+	for _, tf := range testFiles {
+		fileName := filepath.Join(dir, filepath.FromSlash(tf.Name))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fileName, []byte(tf.Body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "archive-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeZip(t, filepath.Join(tmpDir, "arch.zip"))
+	writeTarGz(t, filepath.Join(tmpDir, "arch.tar.gz"))
+	writeDir(t, filepath.Join(tmpDir, "arch"))
+
+	var names []string
+	for _, tf := range testFiles {
+		names = append(names, tf.Name)
+	}
+	sortedNames := []string{ // lexical order of directory
+		"user/_json/data/entries/0123abcd.js",
+		"user/_json/data/feedinfo.js",
+		"user/images/media/pic.jpg",
+	}
+
+	for _, d := range []struct {
+		File  string
+		Names []string
+	}{
+		{"arch.zip", names},
+		{"arch.tar.gz", names},
+		{"arch", sortedNames},
+	} {
+		a, err := Open(filepath.Join(tmpDir, d.File))
+		if err != nil {
+			t.Errorf("%s: cannot open: %v", d.File, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(a.Names, d.Names) {
+			t.Errorf("%s: names %v, expected %v", d.File, a.Names, d.Names)
+		}
+
+		name, ok := a.Find(regexp.MustCompile(`/feedinfo\.js$`))
+		if !ok || name != "user/_json/data/feedinfo.js" {
+			t.Errorf("%s: Find returns %q, %v", d.File, name, ok)
+		}
+
+		v := make(map[string]string)
+		if err := a.ReadJSON(name, &v); err != nil {
+			t.Errorf("%s: cannot read JSON: %v", d.File, err)
+		} else if expected := map[string]string{"id": "user", "type": "user"}; !reflect.DeepEqual(v, expected) {
+			t.Errorf("%s: JSON %v, expected %v", d.File, v, expected)
+		}
+
+		if size, err := a.Size("user/images/media/pic.jpg"); err != nil || size != 5 {
+			t.Errorf("%s: Size returns %d, %v", d.File, size, err)
+		}
+
+		if err := a.Close(); err != nil {
+			t.Errorf("%s: cannot close: %v", d.File, err)
+		}
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "archive-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTarGz(t, filepath.Join(tmpDir, "evil.tar.gz"), "../evil.js")
+	ioutil.WriteFile(filepath.Join(tmpDir, "text.txt"), []byte("just a text"), 0644)
+
+	writeDir(t, filepath.Join(tmpDir, "arch"))
+
+	for _, name := range []string{"evil.tar.gz", "text.txt", "not-exists.zip", "arch/user"} {
+		if a, err := Open(filepath.Join(tmpDir, name)); err == nil {
+			a.Close()
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(tmpDir), "evil.js")); err == nil {
+		t.Error("file is extracted outside of the archive directory")
+	}
+}
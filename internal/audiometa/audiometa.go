@@ -0,0 +1,99 @@
+// Package audiometa reads title, artist and duration of audio files: ID3
+// tags and MPEG frames of MP3, atoms of MP4 (M4A) and Vorbis comments of
+// OGG files. Readers never panic on malformed files, they just return
+// the partial metadata.
+package audiometa
+
+import (
+	"bytes"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/juju/errors"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Meta is an audio file metadata
+type Meta struct {
+	Title    string
+	Artist   string
+	Duration time.Duration // zero if unknown
+}
+
+// ErrUnknownFormat returned if file format is not recognized
+var ErrUnknownFormat = errors.New("unknown audio format")
+
+// Read reads metadata of the audio file
+func Read(body []byte) (meta Meta, err error) {
+	switch {
+	case bytes.HasPrefix(body, []byte("OggS")):
+		meta = readOgg(body)
+	case len(body) >= 8 && string(body[4:8]) == "ftyp":
+		meta = readMP4(body)
+	case bytes.HasPrefix(body, []byte("ID3")) || isMPEGFrame(body) ||
+		len(body) >= 128 && string(body[len(body)-128:len(body)-125]) == "TAG":
+		meta = readMP3(body)
+	default:
+		return meta, ErrUnknownFormat
+	}
+	meta.Title = cleanText(meta.Title)
+	meta.Artist = cleanText(meta.Artist)
+	return meta, nil
+}
+
+func cleanText(s string) string {
+	return strings.TrimSpace(strings.Replace(s, "\u0000", "", -1))
+}
+
+// decodeLegacy decodes 8-bit text. The ID3 standard requires ISO-8859-1,
+// but the old Russian files use cp1251. Text is decoded as cp1251 if it has
+// no less Cyrillic letters (in cp1251) than the ASCII ones.
+func decodeLegacy(b []byte) string {
+	cyr, latin, high := 0, 0, 0
+	for _, c := range b {
+		switch {
+		case c >= 0xC0 || c == 0xA8 || c == 0xB8: // А-я, Ё, ё
+			cyr++
+			high++
+		case c >= 0x80:
+			high++
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			latin++
+		}
+	}
+	if high == 0 {
+		return string(b)
+	}
+	var dec *charmap.Charmap
+	if cyr >= latin && cyr*10 >= high*9 {
+		dec = charmap.Windows1251
+	} else {
+		dec = charmap.ISO8859_1
+	}
+	s, err := dec.NewDecoder().Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(s)
+}
+
+// decodeUTF16 decodes UTF-16 text with the optional BOM
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		if b[0] == 0xFF && b[1] == 0xFE {
+			b, bigEndian = b[2:], false
+		} else if b[0] == 0xFE && b[1] == 0xFF {
+			b, bigEndian = b[2:], true
+		}
+	}
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		if bigEndian {
+			u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+		} else {
+			u = append(u, uint16(b[i+1])<<8|uint16(b[i]))
+		}
+	}
+	return string(utf16.Decode(u))
+}
@@ -0,0 +1,212 @@
+package audiometa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func cp1251(s string) string {
+	b, err := charmap.Windows1251.NewEncoder().String(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func utf16LE(s string) string {
+	b := []byte{0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return string(b)
+}
+
+func id3Frame(ver int, id, data string) string {
+	if ver == 2 {
+		n := len(data)
+		return id + string([]byte{byte(n >> 16), byte(n >> 8), byte(n)}) + data
+	}
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(data)))
+	if ver == 4 {
+		n := len(data)
+		size = []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+	}
+	return id + string(size) + "\x00\x00" + data
+}
+
+func id3Tag(ver int, frames ...string) []byte {
+	body := ""
+	for _, f := range frames {
+		body += f
+	}
+	n := len(body)
+	return []byte("ID3" + string([]byte{byte(ver), 0, 0,
+		byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}) + body)
+}
+
+// mpegFrames returns n MPEG1 Layer III 128 kbps 44.1 kHz frames (417 bytes each)
+func mpegFrames(n int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return bytes.Repeat(frame, n)
+}
+
+func atom(typ string, data ...string) string {
+	body := ""
+	for _, d := range data {
+		body += d
+	}
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(body)+8))
+	return string(size) + typ + body
+}
+
+func mp4File(title, artist string, timescale, duration uint32) []byte {
+	mvhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mvhd[12:], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:], duration)
+	return []byte(atom("ftyp", "M4A \x00\x00\x00\x00") + atom("moov",
+		atom("mvhd", string(mvhd)),
+		atom("udta", atom("meta", "\x00\x00\x00\x00",
+			atom("hdlr", "\x00\x00\x00\x00\x00\x00\x00\x00mdirappl"),
+			atom("ilst",
+				atom("\xA9nam", atom("data", "\x00\x00\x00\x01\x00\x00\x00\x00"+title)),
+				atom("\xA9ART", atom("data", "\x00\x00\x00\x01\x00\x00\x00\x00"+artist)),
+			),
+		)),
+	))
+}
+
+func oggPageBytes(granule int64, serial uint32, packets ...string) []byte {
+	var segs, data []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			segs = append(segs, 255)
+		}
+		segs = append(segs, byte(n))
+		data = append(data, p...)
+	}
+	h := make([]byte, 27)
+	copy(h, "OggS")
+	binary.LittleEndian.PutUint64(h[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(h[14:], serial)
+	h[26] = byte(len(segs))
+	return append(append(h, segs...), data...)
+}
+
+func vorbisComments(comments ...string) string {
+	le := func(n int) string {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(n))
+		return string(b)
+	}
+	s := "\x03vorbis" + le(4) + "test" + le(len(comments))
+	for _, c := range comments {
+		s += le(len(c)) + c
+	}
+	return s
+}
+
+func oggFile(seconds int64, comments ...string) []byte {
+	ident := make([]byte, 30)
+	copy(ident, "\x01vorbis")
+	binary.LittleEndian.PutUint32(ident[12:], 44100)
+	var b []byte
+	b = append(b, oggPageBytes(0, 1, string(ident))...)
+	b = append(b, oggPageBytes(0, 1, vorbisComments(comments...), "\x05vorbis setup")...)
+	b = append(b, oggPageBytes(44100*seconds/2, 1, "audio")...)
+	b = append(b, oggPageBytes(44100*seconds, 1, "audio")...)
+	return b
+}
+
+func TestRead(t *testing.T) {
+	id3v1 := make([]byte, 128)
+	copy(id3v1, "TAG")
+	copy(id3v1[3:], cp1251("Группа крови"))
+	copy(id3v1[33:], "Kino")
+
+	for _, d := range []struct {
+		Name string
+		Body []byte
+		Meta Meta
+	}{
+		{
+			"ID3v2.3 UTF-16 and cp1251",
+			append(id3Tag(3,
+				id3Frame(3, "TIT2", "\x01"+utf16LE("Что такое осень")+"\x00\x00"),
+				id3Frame(3, "TPE1", "\x00"+cp1251("ДДТ")),
+				id3Frame(3, "TLEN", "\x00"+"215000"),
+			), mpegFrames(10)...),
+			Meta{"Что такое осень", "ДДТ", 215 * time.Second},
+		},
+		{
+			"ID3v2.2 Latin-1",
+			append(id3Tag(2,
+				id3Frame(2, "TT2", "\x00Caf\xe9 del Mar"),
+				id3Frame(2, "TP1", "\x00Various"),
+			), mpegFrames(10)...),
+			Meta{"Café del Mar", "Various", 261 * time.Millisecond},
+		},
+		{
+			"ID3v2.4 UTF-8, multiple values",
+			append(id3Tag(4,
+				id3Frame(4, "TIT2", "\x03Song\x00Other"),
+				id3Frame(4, "TPE1", "\x03Артист"),
+			), mpegFrames(1)...),
+			Meta{"Song", "Артист", 26 * time.Millisecond},
+		},
+		{
+			"ID3v1 only",
+			append(mpegFrames(100), id3v1...),
+			Meta{"Группа крови", "Kino", 2606 * time.Millisecond},
+		},
+		{
+			"M4A",
+			mp4File("Title", "Artist", 1000, 61500),
+			Meta{"Title", "Artist", 61500 * time.Millisecond},
+		},
+		{
+			"OGG Vorbis",
+			oggFile(90, "title=Песня", "ARTIST=Автор", "ALBUM=Альбом"),
+			Meta{"Песня", "Автор", 90 * time.Second},
+		},
+	} {
+		meta, err := Read(d.Body)
+		if err != nil {
+			t.Errorf("%s: %v", d.Name, err)
+			continue
+		}
+		meta.Duration = meta.Duration.Round(time.Millisecond)
+		if !reflect.DeepEqual(meta, d.Meta) {
+			t.Errorf("%s: got %+v, expects %+v", d.Name, meta, d.Meta)
+		}
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	bodies := [][]byte{
+		append(id3Tag(3, id3Frame(3, "TIT2", "\x01"+utf16LE("Title"))), mpegFrames(3)...),
+		append(id3Tag(4, id3Frame(4, "TIT2", "\x03Title")), mpegFrames(3)...),
+		mp4File("Title", "Artist", 1000, 1000),
+		oggFile(1, "TITLE=Title"),
+	}
+	for _, body := range bodies {
+		for i := 0; i < len(body); i++ {
+			Read(body[:i])
+			broken := append([]byte{}, body...)
+			broken[i] ^= 0xFF
+			Read(broken)
+		}
+	}
+	if _, err := Read([]byte("not an audio")); err != ErrUnknownFormat {
+		t.Errorf("Read: got %v, expects ErrUnknownFormat", err)
+	}
+}
@@ -0,0 +1,275 @@
+package audiometa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func readMP3(body []byte) (meta Meta) {
+	audioStart, length := readID3v2(body, &meta)
+	audioEnd := len(body)
+	if len(body) >= 128 && string(body[len(body)-128:len(body)-125]) == "TAG" {
+		audioEnd -= 128
+		if meta.Title == "" && meta.Artist == "" {
+			v1 := body[len(body)-128:]
+			meta.Title = decodeLegacy(cutAt0(v1[3:33]))
+			meta.Artist = decodeLegacy(cutAt0(v1[33:63]))
+		}
+	}
+	if length > 0 {
+		meta.Duration = length
+	} else if audioStart < audioEnd {
+		meta.Duration = mpegDuration(body[audioStart:audioEnd])
+	}
+	return
+}
+
+// readID3v2 reads ID3v2.2-2.4 tag and returns the audio data offset and the
+// TLEN value
+func readID3v2(body []byte, meta *Meta) (audioStart int, length time.Duration) {
+	if len(body) < 10 || string(body[:3]) != "ID3" {
+		return 0, 0
+	}
+	ver, flags := body[3], body[5]
+	size := syncsafe(body[6:10])
+	audioStart = 10 + size
+	if flags&0x10 != 0 { // footer
+		audioStart += 10
+	}
+	if ver < 2 || ver > 4 || 10+size > len(body) {
+		return min(audioStart, len(body)), 0
+	}
+
+	tag := body[10 : 10+size]
+	if flags&0x80 != 0 && ver < 4 {
+		tag = removeUnsync(tag)
+	}
+	if flags&0x40 != 0 && ver > 2 && len(tag) >= 4 { // extended header
+		extSize := int(binary.BigEndian.Uint32(tag)) + 4
+		if ver == 4 {
+			extSize = syncsafe(tag[:4])
+		}
+		if extSize > len(tag) {
+			return audioStart, 0
+		}
+		tag = tag[extSize:]
+	}
+
+	idLen, headerLen := 4, 10
+	if ver == 2 {
+		idLen, headerLen = 3, 6
+	}
+	for len(tag) >= headerLen && tag[0] != 0 {
+		id := string(tag[:idLen])
+		var frameSize int
+		switch ver {
+		case 2:
+			frameSize = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(tag[4:8]))
+		case 4:
+			frameSize = syncsafe(tag[4:8])
+		}
+		if frameSize < 0 || headerLen+frameSize > len(tag) {
+			break
+		}
+		data := tag[headerLen : headerLen+frameSize]
+		if ver > 2 {
+			fmtFlags := tag[9]
+			if ver == 3 && fmtFlags&0xC0 != 0 || ver == 4 && fmtFlags&0x0C != 0 {
+				data = nil // compressed or encrypted
+			} else if ver == 4 {
+				if fmtFlags&0x02 != 0 {
+					data = removeUnsync(data)
+				}
+				if fmtFlags&0x01 != 0 && len(data) >= 4 { // data length indicator
+					data = data[4:]
+				}
+			}
+		}
+		tag = tag[headerLen+frameSize:]
+
+		if len(data) == 0 {
+			continue
+		}
+		switch id {
+		case "TT2", "TIT2":
+			meta.Title = textFrame(data)
+		case "TP1", "TPE1":
+			meta.Artist = textFrame(data)
+		case "TLE", "TLEN":
+			if ms, err := strconv.Atoi(strings.TrimSpace(textFrame(data))); err == nil && ms > 0 {
+				length = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return min(audioStart, len(body)), length
+}
+
+// textFrame decodes the first value of ID3v2 text frame
+func textFrame(data []byte) string {
+	enc, text := data[0], data[1:]
+	switch enc {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		for i := 0; i+1 < len(text); i += 2 {
+			if text[i] == 0 && text[i+1] == 0 {
+				text = text[:i]
+				break
+			}
+		}
+		return decodeUTF16(text, enc == 2)
+	case 3: // UTF-8
+		return string(cutAt0(text))
+	}
+	return decodeLegacy(cutAt0(text))
+}
+
+func cutAt0(b []byte) []byte {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return b[:i]
+	}
+	return b
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// removeUnsync reverts the ID3 unsynchronisation: 0xFF 0x00 -> 0xFF
+func removeUnsync(b []byte) []byte {
+	return bytes.Replace(b, []byte{0xFF, 0x00}, []byte{0xFF}, -1)
+}
+
+// MPEG audio frame header
+
+type mpegHeader struct {
+	Version    int // 1, 2 or 25 (for 2.5)
+	Layer      int
+	Bitrate    int // kbps
+	SampleRate int
+	Padding    int
+	Mono       bool
+}
+
+var mpegBitrates = map[[2]int][]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var mpegSampleRates = map[int][]int{
+	1:  {44100, 48000, 32000},
+	2:  {22050, 24000, 16000},
+	25: {11025, 12000, 8000},
+}
+
+func parseMPEGHeader(b []byte) (h mpegHeader, ok bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return h, false
+	}
+	switch (b[1] >> 3) & 3 {
+	case 0:
+		h.Version = 25
+	case 2:
+		h.Version = 2
+	case 3:
+		h.Version = 1
+	default:
+		return h, false
+	}
+	h.Layer = 4 - int((b[1]>>1)&3)
+	brIdx, srIdx := int(b[2]>>4), int((b[2]>>2)&3)
+	if h.Layer == 4 || brIdx == 0 || brIdx == 15 || srIdx == 3 {
+		return h, false
+	}
+	v := h.Version
+	if v == 25 {
+		v = 2
+	}
+	h.Bitrate = mpegBitrates[[2]int{v, h.Layer}][brIdx]
+	h.SampleRate = mpegSampleRates[h.Version][srIdx]
+	h.Padding = int((b[2] >> 1) & 1)
+	h.Mono = b[3]>>6 == 3
+	return h, true
+}
+
+func (h mpegHeader) samplesPerFrame() int {
+	switch {
+	case h.Layer == 1:
+		return 384
+	case h.Layer == 3 && h.Version != 1:
+		return 576
+	}
+	return 1152
+}
+
+func (h mpegHeader) frameLen() int {
+	if h.Layer == 1 {
+		return (12*h.Bitrate*1000/h.SampleRate + h.Padding) * 4
+	}
+	return h.samplesPerFrame()/8*h.Bitrate*1000/h.SampleRate + h.Padding
+}
+
+func isMPEGFrame(b []byte) bool {
+	_, ok := parseMPEGHeader(b)
+	return ok
+}
+
+// mpegDuration calculates duration of the MPEG audio stream by the Xing/VBRI
+// header or by bitrate of the first frame
+func mpegDuration(audio []byte) time.Duration {
+	// Find the first frame, the next frame must follow it
+	pos := -1
+	var h mpegHeader
+	for i := 0; i+4 <= len(audio) && i < 64*1024; i++ {
+		var ok bool
+		if h, ok = parseMPEGHeader(audio[i:]); !ok {
+			continue
+		}
+		next := i + h.frameLen()
+		if next+4 <= len(audio) && !isMPEGFrame(audio[next:]) {
+			continue
+		}
+		pos = i
+		break
+	}
+	if pos < 0 {
+		return 0
+	}
+	frame := audio[pos:]
+
+	// Xing/Info header of VBR files
+	sideInfo := 32
+	if h.Version == 1 && h.Mono || h.Version != 1 && !h.Mono {
+		sideInfo = 17
+	} else if h.Version != 1 && h.Mono {
+		sideInfo = 9
+	}
+	frames := 0
+	if x := frame[min(len(frame), 4+sideInfo):]; len(x) >= 12 && (string(x[:4]) == "Xing" || string(x[:4]) == "Info") {
+		if binary.BigEndian.Uint32(x[4:8])&1 != 0 {
+			frames = int(binary.BigEndian.Uint32(x[8:12]))
+		}
+	} else if x := frame[min(len(frame), 36):]; len(x) >= 18 && string(x[:4]) == "VBRI" {
+		frames = int(binary.BigEndian.Uint32(x[14:18]))
+	}
+	if frames > 0 {
+		return time.Duration(frames) * time.Duration(h.samplesPerFrame()) * time.Second / time.Duration(h.SampleRate)
+	}
+
+	// CBR
+	return time.Duration(len(frame)) * 8 * time.Second / time.Duration(h.Bitrate*1000)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
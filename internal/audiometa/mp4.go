@@ -0,0 +1,88 @@
+package audiometa
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// atoms calls fn for each atom in b
+func atoms(b []byte, fn func(typ string, data []byte)) {
+	for len(b) >= 8 {
+		size := int64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		header := int64(8)
+		switch size {
+		case 0: // to the end
+			size = int64(len(b))
+		case 1: // 64-bit size
+			if len(b) < 16 {
+				return
+			}
+			size, header = int64(binary.BigEndian.Uint64(b[8:16])), 16
+		}
+		if size < header || size > int64(len(b)) {
+			return
+		}
+		fn(typ, b[header:size])
+		b = b[size:]
+	}
+}
+
+// findAtom returns data of the atom by path, like "moov", "udta"
+func findAtom(b []byte, path ...string) (res []byte, ok bool) {
+	if len(path) == 0 {
+		return b, true
+	}
+	atoms(b, func(typ string, data []byte) {
+		if !ok && typ == path[0] {
+			if typ == "meta" { // full box
+				if len(data) < 4 {
+					return
+				}
+				data = data[4:]
+			}
+			res, ok = findAtom(data, path[1:]...)
+		}
+	})
+	return
+}
+
+func readMP4(body []byte) (meta Meta) {
+	if ilst, ok := findAtom(body, "moov", "udta", "meta", "ilst"); ok {
+		atoms(ilst, func(typ string, item []byte) {
+			var dst *string
+			switch typ {
+			case "\xA9nam":
+				dst = &meta.Title
+			case "\xA9ART":
+				dst = &meta.Artist
+			default:
+				return
+			}
+			atoms(item, func(typ string, data []byte) {
+				// data: type (4), locale (4), value
+				if typ == "data" && len(data) >= 8 && binary.BigEndian.Uint32(data)&0xFFFFFF == 1 {
+					*dst = string(data[8:])
+				}
+			})
+		})
+	}
+
+	if mvhd, ok := findAtom(body, "moov", "mvhd"); ok && len(mvhd) >= 20 {
+		var timescale, duration uint64
+		if mvhd[0] == 1 { // version 1
+			if len(mvhd) < 32 {
+				return
+			}
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+			duration = binary.BigEndian.Uint64(mvhd[24:32])
+		} else {
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+		}
+		if timescale > 0 {
+			meta.Duration = time.Duration(duration) * time.Second / time.Duration(timescale)
+		}
+	}
+	return
+}
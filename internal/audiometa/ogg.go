@@ -0,0 +1,152 @@
+package audiometa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type oggPage struct {
+	Granule  int64
+	Serial   uint32
+	Segments []byte // lacing values
+	Data     []byte
+}
+
+// oggPages calls fn for each page, fn returns false to stop
+func oggPages(b []byte, fn func(p *oggPage) bool) {
+	for len(b) >= 27 && string(b[:4]) == "OggS" {
+		nSegs := int(b[26])
+		if len(b) < 27+nSegs {
+			return
+		}
+		p := &oggPage{
+			Granule:  int64(binary.LittleEndian.Uint64(b[6:14])),
+			Serial:   binary.LittleEndian.Uint32(b[14:18]),
+			Segments: b[27 : 27+nSegs],
+		}
+		size := 0
+		for _, s := range p.Segments {
+			size += int(s)
+		}
+		if len(b) < 27+nSegs+size {
+			return
+		}
+		p.Data = b[27+nSegs : 27+nSegs+size]
+		if !fn(p) {
+			return
+		}
+		b = b[27+nSegs+size:]
+	}
+}
+
+func readOgg(body []byte) (meta Meta) {
+	var (
+		serial     uint32
+		packets    [][]byte
+		packet     []byte
+		sampleRate int64
+		preSkip    int64
+		lastGran   int64
+	)
+	oggPages(body, func(p *oggPage) bool {
+		if len(packets) == 0 && packet == nil {
+			serial = p.Serial // the first logical stream
+		}
+		if p.Serial != serial {
+			return true
+		}
+		if p.Granule > 0 {
+			lastGran = p.Granule
+		}
+		if len(packets) >= 2 {
+			return true // only the last granule position is needed
+		}
+		data := p.Data
+		for _, s := range p.Segments {
+			packet = append(packet, data[:s]...)
+			data = data[s:]
+			if s < 255 {
+				packets = append(packets, packet)
+				packet = []byte{}
+				if len(packets) >= 2 {
+					break
+				}
+			}
+		}
+		return true
+	})
+	if len(packets) < 2 {
+		return
+	}
+
+	ident, comments := packets[0], packets[1]
+	switch {
+	case bytes.HasPrefix(ident, []byte("\x01vorbis")) && len(ident) >= 16:
+		sampleRate = int64(binary.LittleEndian.Uint32(ident[12:16]))
+		if bytes.HasPrefix(comments, []byte("\x03vorbis")) {
+			readVorbisComments(comments[7:], &meta)
+		}
+	case bytes.HasPrefix(ident, []byte("OpusHead")) && len(ident) >= 12:
+		sampleRate = 48000 // granule positions are always at 48 kHz
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:12]))
+		if bytes.HasPrefix(comments, []byte("OpusTags")) {
+			readVorbisComments(comments[8:], &meta)
+		}
+	}
+	if sampleRate > 0 && lastGran > preSkip {
+		meta.Duration = time.Duration(lastGran-preSkip) * time.Second / time.Duration(sampleRate)
+	}
+	return
+}
+
+// readVorbisComments reads the Vorbis comment header (without the packet
+// type prefix)
+func readVorbisComments(b []byte, meta *Meta) {
+	next := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := int64(binary.LittleEndian.Uint32(b))
+		if int64(len(b)-4) < n {
+			return nil, false
+		}
+		s := b[4 : 4+n]
+		b = b[4+n:]
+		return s, true
+	}
+	if _, ok := next(); !ok { // vendor
+		return
+	}
+	if len(b) < 4 {
+		return
+	}
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	for i := 0; i < count; i++ {
+		c, ok := next()
+		if !ok {
+			return
+		}
+		parts := strings.SplitN(string(c), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := parts[1]
+		if !utf8.ValidString(value) { // old non-standard files
+			value = decodeLegacy([]byte(value))
+		}
+		switch strings.ToUpper(parts[0]) {
+		case "TITLE":
+			if meta.Title == "" {
+				meta.Title = value
+			}
+		case "ARTIST":
+			if meta.Artist == "" {
+				meta.Artist = value
+			}
+		}
+	}
+}
@@ -1,14 +1,8 @@
 package clio
 
 import (
-	"io/ioutil"
-	"log"
-	"net/http"
-	"net/url"
-	"os"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/davidmz/mustbe"
 	"github.com/juju/errors"
@@ -67,55 +61,10 @@ func deHTML(text string) (result string, links []string) {
 				links = append(links, aTitle)
 				result += aTitle
 			} else {
-				url := unshorten(aHref)
+				url := Unshorten.Expand(aHref)
 				links = append(links, url)
 				result += url
 			}
 		}
 	}
 }
-
-var shortDomains = []string{
-	"t.co",
-	"bit.ly",
-	"bitly.com",
-	"bitly.is",
-	"j.mp",
-	"goo.gl",
-	"tinyurl.com",
-	"ow.ly",
-	"b23.ru",
-}
-
-var httpClient = &http.Client{
-	Timeout: 10 * time.Second,
-}
-
-func unshorten(u string) string {
-	pURL, err := url.Parse(u)
-	if err != nil {
-		return u
-	}
-	isShort := false
-	for _, domain := range shortDomains {
-		if pURL.Hostname() == domain {
-			isShort = true
-			break
-		}
-	}
-	if !isShort {
-		return u
-	}
-
-	u1 := strings.Replace(u, "://b23.ru/", "://z23.ru/", 1)
-
-	// turn off log because of https://github.com/golang/go/issues/19895
-	log.SetOutput(ioutil.Discard)
-	resp, err := httpClient.Head(u1)
-	log.SetOutput(os.Stderr)
-	if err != nil {
-		return u
-	}
-	resp.Body.Close()
-	return resp.Request.URL.String()
-}
@@ -46,12 +46,7 @@ func (entry *Entry) UnmarshalJSON(data []byte) error {
 
 // Init initialize entry after unmarshalling
 func (entry *Entry) Init(accs *account.Store) {
-	entry.Body, entry.Links = deHTML(entry.Body)
-	entry.Hashtags = hashtags.Extract(entry.Body)
-	for _, c := range entry.Comments {
-		c.Body, _ = deHTML(c.Body)
-		c.Hashtags = hashtags.Extract(c.Body)
-	}
+	entry.InitText()
 
 	entry.Author = accs.Get(entry.AuthorName)
 	for _, c := range entry.Comments {
@@ -62,6 +57,17 @@ func (entry *Entry) Init(accs *account.Store) {
 	}
 }
 
+// InitText initialize entry and comments texts (and entry links) after
+// unmarshalling. Unlike Init it doesn't touch the DB.
+func (entry *Entry) InitText() {
+	entry.Body, entry.Links = deHTML(entry.Body)
+	entry.Hashtags = hashtags.Extract(entry.Body)
+	for _, c := range entry.Comments {
+		c.Body, _ = deHTML(c.Body)
+		c.Hashtags = hashtags.Extract(c.Body)
+	}
+}
+
 // Comment represents archived comment
 type Comment struct {
 	commentJSON
@@ -0,0 +1,219 @@
+package clio
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/FreeFeed/clio-restore/internal/config"
+	"github.com/FreeFeed/clio-restore/internal/fetch"
+	"github.com/FreeFeed/clio-restore/internal/logging"
+	"github.com/juju/errors"
+)
+
+// DefaultShortDomains are the shortener hostnames used if config doesn't
+// define them. The b23.ru links are requested via z23.ru.
+var DefaultShortDomains = []string{
+	"t.co",
+	"bit.ly",
+	"bitly.com",
+	"bitly.is",
+	"j.mp",
+	"goo.gl",
+	"tinyurl.com",
+	"ow.ly",
+	"b23.ru z23.ru",
+}
+
+// FinalURL returns URL after all redirects. It is used to unshorten links
+// and can be replaced (i.e. to use the media cache).
+var FinalURL = fetch.Default.FinalURL
+
+// Unshorten expands the short links in entry texts
+var Unshorten = &Unshortener{
+	hosts: mustParseDomains(DefaultShortDomains),
+	known: make(map[string]string),
+}
+
+// Unshortener expands the short links. The known expansions are taken from
+// the mapping files and the cache file, the new ones are appended to the cache
+// file. Unshortener is safe for concurrent use.
+type Unshortener struct {
+	hosts     map[string]string // shortener host -> host to request
+	dead      map[string]bool   // shorteners that are not requested
+	cacheFile string
+
+	mu    sync.Mutex
+	known map[string]string // short URL -> full URL
+}
+
+// NewUnshortener creates Unshortener according to config. The read-only
+// Unshortener doesn't write the new expansions to the cache file. If the cache
+// file is not writable, the error is logged and the cache is used read-only.
+func NewUnshortener(conf *config.Unshorten, readOnly bool) (*Unshortener, error) {
+	domains := conf.Domain
+	if len(domains) == 0 {
+		domains = DefaultShortDomains
+	}
+	hosts, err := parseDomains(domains)
+	if err != nil {
+		return nil, err
+	}
+	u := &Unshortener{
+		hosts:     hosts,
+		dead:      make(map[string]bool),
+		cacheFile: conf.Cache,
+		known:     make(map[string]string),
+	}
+	for _, host := range conf.Dead {
+		u.dead[host] = true
+	}
+	for _, fileName := range conf.Mapping {
+		if err := u.load(fileName, true); err != nil {
+			return nil, err
+		}
+	}
+	if u.cacheFile != "" {
+		if err := u.load(u.cacheFile, false); err != nil && !os.IsNotExist(errors.Cause(err)) {
+			logging.Error.Println("Cannot read unshorten cache:", err)
+		}
+		if readOnly {
+			u.cacheFile = ""
+		} else if f, err := os.OpenFile(u.cacheFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			logging.Error.Println("Unshorten cache is not writable, new expansions are not saved:", err)
+			u.cacheFile = ""
+		} else {
+			f.Close()
+		}
+	}
+	return u, nil
+}
+
+// NeedsLookup returns true if the link is a short link that is not known yet
+// and Expand would request it
+func (u *Unshortener) NeedsLookup(link string) bool {
+	pURL, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := pURL.Hostname()
+	if _, ok := u.hosts[host]; !ok || u.dead[host] {
+		return false
+	}
+	u.mu.Lock()
+	_, ok := u.known[link]
+	u.mu.Unlock()
+	return !ok
+}
+
+// Expand returns the full URL of the short link. Other links and the links
+// that cannot be expanded are returned as is.
+func (u *Unshortener) Expand(link string) string {
+	pURL, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	host := pURL.Hostname()
+	reqHost, ok := u.hosts[host]
+	if !ok {
+		return link
+	}
+
+	u.mu.Lock()
+	full, ok := u.known[link]
+	u.mu.Unlock()
+	if ok {
+		return full
+	}
+	if u.dead[host] {
+		return link
+	}
+
+	reqURL := link
+	if reqHost != host {
+		pURL.Host = strings.Replace(pURL.Host, host, reqHost, 1)
+		reqURL = pURL.String()
+	}
+	full, err = FinalURL(reqURL)
+	if err != nil || full == reqURL {
+		return link
+	}
+	u.remember(link, full)
+	return full
+}
+
+func (u *Unshortener) remember(link, full string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.known[link] = full
+	if u.cacheFile == "" {
+		return
+	}
+	// The cache is append-only, so it can be shared by the parallel runs
+	f, err := os.OpenFile(u.cacheFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(f, "%s %s\n", link, full)
+	f.Close()
+}
+
+// load reads the "SHORT-URL FULL-URL" lines from the file. Empty lines and
+// lines starting with # are ignored. The invalid lines are errors in the
+// strict mode and are skipped otherwise (the cache can have the torn lines
+// written by the crashed runs).
+func (u *Unshortener) load(fileName string, strict bool) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return errors.Annotate(err, "cannot read unshorten mapping")
+	}
+	defer f.Close()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			if strict {
+				return errors.Errorf("%s:%d: invalid line %q", fileName, n, line)
+			}
+			logging.Error.Printf("%s:%d: invalid line is skipped: %q", fileName, n, line)
+			continue
+		}
+		u.known[parts[0]] = parts[1]
+	}
+	return errors.Annotate(scanner.Err(), fileName)
+}
+
+// parseDomains parses the "HOST" or "HOST NEWHOST" lines
+func parseDomains(domains []string) (map[string]string, error) {
+	hosts := make(map[string]string)
+	for _, d := range domains {
+		parts := strings.Fields(d)
+		switch len(parts) {
+		case 1:
+			hosts[parts[0]] = parts[0]
+		case 2:
+			hosts[parts[0]] = parts[1]
+		default:
+			return nil, errors.Errorf("invalid shortener domain %q", d)
+		}
+	}
+	return hosts, nil
+}
+
+func mustParseDomains(domains []string) map[string]string {
+	hosts, err := parseDomains(domains)
+	if err != nil {
+		panic(err)
+	}
+	return hosts
+}
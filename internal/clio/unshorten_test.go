@@ -0,0 +1,119 @@
+package clio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/FreeFeed/clio-restore/internal/config"
+)
+
+func TestUnshortener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unshorten")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mapping := filepath.Join(dir, "mapping.txt")
+	if err := ioutil.WriteFile(mapping, []byte("# goo.gl links\nhttp://goo.gl/abc http://example.com/abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var requested []string
+	defer func(f func(string) (string, error)) { FinalURL = f }(FinalURL)
+	FinalURL = func(u string) (string, error) {
+		requested = append(requested, u)
+		switch u {
+		case "http://bit.ly/x", "http://z23.ru/y":
+			return "http://example.com/long", nil
+		}
+		return u, nil // not expanded
+	}
+
+	conf := &config.Unshorten{
+		Domain:  []string{"bit.ly", "goo.gl", "b23.ru z23.ru"},
+		Dead:    []string{"goo.gl"},
+		Cache:   filepath.Join(dir, "cache.txt"),
+		Mapping: []string{mapping},
+	}
+	u, err := NewUnshortener(conf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []struct{ Link, Result string }{
+		{"http://example.org/x", "http://example.org/x"}, // not a shortener
+		{"http://bit.ly/x", "http://example.com/long"},   // expanded
+		{"http://bit.ly/x", "http://example.com/long"},   // from memory
+		{"http://bit.ly/404", "http://bit.ly/404"},       // not expanded
+		{"http://b23.ru/y", "http://example.com/long"},   // via z23.ru
+		{"http://goo.gl/abc", "http://example.com/abc"},  // from mapping
+		{"http://goo.gl/other", "http://goo.gl/other"},   // dead
+	} {
+		if r := u.Expand(d.Link); r != d.Result {
+			t.Errorf("%s: got %q, expects %q", d.Link, r, d.Result)
+		}
+	}
+	if len(requested) != 3 {
+		t.Errorf("expects 3 requests, got %v", requested)
+	}
+	for _, d := range []struct {
+		Link   string
+		Lookup bool
+	}{
+		{"http://example.org/x", false}, // not a shortener
+		{"http://bit.ly/x", false},      // known
+		{"http://bit.ly/new", true},
+		{"http://goo.gl/abc", false},   // from mapping
+		{"http://goo.gl/other", false}, // dead
+	} {
+		if l := u.NeedsLookup(d.Link); l != d.Lookup {
+			t.Errorf("%s: got lookup %v, expects %v", d.Link, l, d.Lookup)
+		}
+	}
+
+	// New Unshortener takes expansions from the cache, torn lines are skipped
+	f, err := os.OpenFile(conf.Cache, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("http://bit.ly/torn\n")
+	f.Close()
+	requested = nil
+	u, err = NewUnshortener(conf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := u.Expand("http://b23.ru/y"); r != "http://example.com/long" || len(requested) != 0 {
+		t.Errorf("cached link: got %q and requests %v", r, requested)
+	}
+
+	if _, err := NewUnshortener(&config.Unshorten{Domain: []string{"a b c"}}, false); err == nil {
+		t.Error("invalid domain is accepted")
+	}
+	if _, err := NewUnshortener(&config.Unshorten{Mapping: []string{conf.Cache}}, false); err == nil {
+		t.Error("invalid mapping line is accepted")
+	}
+
+	// Read-only and unwritable caches are not written
+	for _, d := range []struct {
+		Cache    string
+		ReadOnly bool
+	}{
+		{filepath.Join(dir, "ro.txt"), true},
+		{filepath.Join(dir, "no-such-dir", "cache.txt"), false},
+	} {
+		u, err := NewUnshortener(&config.Unshorten{Domain: []string{"bit.ly"}, Cache: d.Cache}, d.ReadOnly)
+		if err != nil {
+			t.Fatalf("%s: %v", d.Cache, err)
+		}
+		if r := u.Expand("http://bit.ly/x"); r != "http://example.com/long" {
+			t.Errorf("%s: got %q", d.Cache, r)
+		}
+		if _, err := os.Stat(d.Cache); !os.IsNotExist(err) {
+			t.Errorf("%s: cache is written", d.Cache)
+		}
+	}
+}
@@ -4,26 +4,46 @@ import (
 	"flag"
 	"path/filepath"
 
+	"github.com/FreeFeed/clio-restore/internal/fetch"
 	"gopkg.in/gcfg.v1"
 )
 import "os"
 
 // Config holds program configuration taken from ini file
 type Config struct {
-	DbStr        string
-	GM           string
-	GifSicle     string
-	SRGB         string
-	AttDir       string
-	S3Bucket     string
-	MP3Zip       string
-	AttURL       string
-	SMTPHost     string
-	SMTPPort     int
-	SMTPUsername string
-	SMTPPassword string
-	SMTPFrom     string
-	SMTPBcc      string
+	DbStr          string
+	GM             string
+	GifSicle       string
+	ImageProcessor string
+	SRGB           string
+	Storage        string
+	AttDir         string
+	S3Bucket       string
+	S3Endpoint     string
+	S3Region       string
+	S3PathStyle    bool
+	MP3Zip         string
+	AttURL         string
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+	SMTPFrom       string
+	SMTPBcc        string
+	OrphansFile    string
+	ThumbRules     string
+	MediaCache     string
+	DedupExisting  bool
+	Workers        int
+	ImageWorkers   int
+	ImageMetadata  string
+
+	// ImageSizes are image size presets from the [ImageSize "ID"] sections
+	ImageSizes map[string]*ImageSize
+	// Fetch are the remote media fetching settings from the [Fetch] section
+	Fetch fetch.Options
+	// Unshorten are the short links expansion settings from the [Unshorten] section
+	Unshorten Unshorten
 }
 
 var fileName string
@@ -38,9 +58,29 @@ func Load() (*Config, error) {
 	if fileName == "" {
 		fileName = filepath.Join(filepath.Dir(os.Args[0]), "clio.ini")
 	}
-	conf := &struct{ Clio Config }{}
+	conf := &struct {
+		Clio      Config
+		ImageSize map[string]*ImageSize
+		Fetch     Fetch
+		Unshorten Unshorten
+	}{Fetch: defaultFetch()}
 	if err := gcfg.ReadFileInto(conf, fileName); err != nil {
 		return nil, err
 	}
+	sizes, err := imageSizes(conf.ImageSize)
+	if err != nil {
+		return nil, err
+	}
+	conf.Clio.ImageSizes = sizes
+	if conf.Clio.Fetch, err = conf.Fetch.options(); err != nil {
+		return nil, err
+	}
+	if conf.Clio.OrphansFile == "" {
+		conf.Clio.OrphansFile = filepath.Join(filepath.Dir(fileName), "clio-orphans.txt")
+	}
+	if conf.Clio.ThumbRules == "" {
+		conf.Clio.ThumbRules = filepath.Join(filepath.Dir(fileName), "thumb-rules.json")
+	}
+	conf.Clio.Unshorten = conf.Unshorten
 	return &conf.Clio, nil
 }
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"time"
+
+	"github.com/FreeFeed/clio-restore/internal/fetch"
+	"github.com/juju/errors"
+)
+
+// Fetch holds the remote media fetching settings from the [Fetch] section
+type Fetch struct {
+	UserAgent       string
+	Timeout         int     // timeout of a single attempt, seconds
+	Retries         int     // retries of the failed requests
+	Backoff         int     // delay before the first retry (doubled on each next), milliseconds
+	HostConns       int     // max parallel requests to a host, 0 is unlimited
+	HostRate        float64 // max requests per second to a host, 0 is unlimited
+	BreakerFailures int     // failures in a row after which the host is not requested, 0 disables
+	BreakerCooldown int     // time the failed host is not requested, seconds
+}
+
+func defaultFetch() Fetch {
+	opts := fetch.DefaultOptions()
+	return Fetch{
+		UserAgent:       opts.UserAgent,
+		Timeout:         int(opts.Timeout / time.Second),
+		Retries:         opts.Retries,
+		Backoff:         int(opts.Backoff / time.Millisecond),
+		HostConns:       opts.HostConns,
+		HostRate:        opts.HostRate,
+		BreakerFailures: opts.BreakerFailures,
+		BreakerCooldown: int(opts.BreakerCooldown / time.Second),
+	}
+}
+
+// options validates the settings and converts them to fetch.Options
+func (f Fetch) options() (fetch.Options, error) {
+	if f.Timeout <= 0 {
+		return fetch.Options{}, errors.New("fetch: Timeout must be positive")
+	}
+	if f.Retries < 0 || f.Backoff < 0 || f.HostConns < 0 || f.HostRate < 0 ||
+		f.BreakerFailures < 0 || f.BreakerCooldown < 0 {
+		return fetch.Options{}, errors.New("fetch: settings cannot be negative")
+	}
+	return fetch.Options{
+		UserAgent:       f.UserAgent,
+		Timeout:         time.Duration(f.Timeout) * time.Second,
+		Retries:         f.Retries,
+		Backoff:         time.Duration(f.Backoff) * time.Millisecond,
+		HostConns:       f.HostConns,
+		HostRate:        f.HostRate,
+		BreakerFailures: f.BreakerFailures,
+		BreakerCooldown: time.Duration(f.BreakerCooldown) * time.Second,
+	}, nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import "github.com/juju/errors"
+
+// ImageSize is an image size preset. The "o" preset is for the original
+// image, its Width and Height (if defined) limit the original image size.
+type ImageSize struct {
+	Width   int
+	Height  int
+	Dir     string   // storage directory, like "attachments/thumbnails"
+	Quality int      // quality of the lossy formats, default is 95
+	Alt     []string // alternate encodings of image, like "webp"
+}
+
+// OriginalSize is an ID of the original image preset
+const OriginalSize = "o"
+
+// AltFormats are the supported alternate encodings and their MIME types. The
+// alternate files have the format name as the extension: "UID.webp".
+var AltFormats = map[string]string{
+	"webp": "image/webp",
+	"avif": "image/avif",
+}
+
+const defaultQuality = 95
+
+// DefaultImageSizes returns the image size presets used if ini file doesn't
+// define them
+func DefaultImageSizes() map[string]*ImageSize {
+	return map[string]*ImageSize{
+		OriginalSize: {Dir: "attachments", Quality: defaultQuality},
+		"t":          {Width: 525, Height: 175, Dir: "attachments/thumbnails", Quality: defaultQuality},
+		"t2":         {Width: 1050, Height: 350, Dir: "attachments/thumbnails2", Quality: defaultQuality},
+	}
+}
+
+// imageSizes validates the presets and fills the defaults
+func imageSizes(sizes map[string]*ImageSize) (map[string]*ImageSize, error) {
+	if len(sizes) == 0 {
+		return DefaultImageSizes(), nil
+	}
+	if sizes[OriginalSize] == nil {
+		sizes[OriginalSize] = DefaultImageSizes()[OriginalSize]
+	}
+	for id, sz := range sizes {
+		if sz.Quality == 0 {
+			sz.Quality = defaultQuality
+		}
+		if sz.Dir == "" {
+			return nil, errors.Errorf("image size %q: Dir is required", id)
+		}
+		if id != OriginalSize && (sz.Width <= 0 || sz.Height <= 0) {
+			return nil, errors.Errorf("image size %q: Width and Height are required", id)
+		}
+		for _, f := range sz.Alt {
+			if _, ok := AltFormats[f]; !ok {
+				return nil, errors.Errorf("image size %q: unsupported alternate format %q", id, f)
+			}
+		}
+	}
+	return sizes, nil
+}
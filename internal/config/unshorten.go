@@ -0,0 +1,10 @@
+package config
+
+// Unshorten holds the short links expansion settings from the [Unshorten]
+// section
+type Unshorten struct {
+	Domain  []string // shortener hostnames, "HOST NEWHOST" requests links via NEWHOST
+	Dead    []string // shorteners that don't work anymore, expanded only from Cache and Mapping
+	Cache   string   // file of the expanded links shared across runs, optional
+	Mapping []string // files of the known expansions ("SHORT-URL FULL-URL" lines)
+}
@@ -0,0 +1,316 @@
+// Package fetch is an HTTP client for the remote media. It retries the failed
+// requests with exponential backoff, limits the number of parallel requests
+// and the request rate per host and stops requesting the hosts that fail
+// repeatedly (circuit breaker).
+package fetch
+
+import (
+	"context"
+	stderrors "errors"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// DefaultUserAgent is the User-Agent header of requests
+const DefaultUserAgent = "clio-restore/1.0 (+https://github.com/FreeFeed/clio-restore)"
+
+// maxBackoff limits the delay between retries
+const maxBackoff = time.Minute
+
+// Options are the Client settings
+type Options struct {
+	UserAgent       string
+	Timeout         time.Duration // timeout of a single attempt
+	Retries         int           // number of retries after the first attempt
+	Backoff         time.Duration // delay before the first retry, doubled on each next
+	HostConns       int           // max parallel requests to a host, 0 is unlimited
+	HostRate        float64       // max requests per second to a host, 0 is unlimited
+	BreakerFailures int           // consecutive failures that open the host circuit, 0 disables the breaker
+	BreakerCooldown time.Duration // time the host is not requested after the circuit is opened
+}
+
+// DefaultOptions returns the default Client settings
+func DefaultOptions() Options {
+	return Options{
+		UserAgent:       DefaultUserAgent,
+		Timeout:         20 * time.Second,
+		Retries:         3,
+		Backoff:         time.Second,
+		HostConns:       4,
+		HostRate:        5,
+		BreakerFailures: 10,
+		BreakerCooldown: 5 * time.Minute,
+	}
+}
+
+// Default is the Client with the default settings
+var Default = New(DefaultOptions())
+
+// Response is a fetched response with the whole body read
+type Response struct {
+	Status      int
+	ContentType string
+	FinalURL    string // URL after all redirects
+	Body        []byte // nil for the HEAD requests
+}
+
+// ErrCircuitOpen is returned for the hosts that failed too many times in a row
+var ErrCircuitOpen = errors.New("host is not available (too many failures)")
+
+// Client performs requests. Client is safe for concurrent use.
+type Client struct {
+	Options
+	HTTP *http.Client // must not have Timeout, it would limit all the attempts together
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+// New creates a new Client
+func New(opts Options) *Client {
+	return &Client{
+		Options: opts,
+		HTTP:    new(http.Client),
+		hosts:   make(map[string]*host),
+	}
+}
+
+// Get performs the GET request
+func (c *Client) Get(url string) (*Response, error) { return c.Do("GET", url) }
+
+// FinalURL returns the URL after all redirects using the HEAD request
+func (c *Client) FinalURL(url string) (string, error) {
+	resp, err := c.Do("HEAD", url)
+	if err != nil {
+		return "", err
+	}
+	return resp.FinalURL, nil
+}
+
+// Do performs the request. The network errors and the 429 and 5xx responses
+// are retried. The non-200 response is not an error, the last response is
+// returned if all retries fail.
+func (c *Client) Do(method, urlStr string) (*Response, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	h := c.host(u.Hostname())
+
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		if !h.allow(c.BreakerFailures) {
+			return nil, errors.Annotate(ErrCircuitOpen, u.Hostname())
+		}
+
+		resp, retryAfter, err := c.attempt(h, method, urlStr)
+		retry := transient(resp, err)
+		if fh := failedHost(err); fh != "" && fh != u.Hostname() {
+			// The host is alive, it redirected us to the failed one
+			h.done(false, c.BreakerFailures, c.BreakerCooldown)
+			c.host(fh).done(true, c.BreakerFailures, c.BreakerCooldown)
+		} else {
+			// Any transport error means that host may be dead; 429 means
+			// that host is alive but throttles us
+			failed := err != nil || (retry && resp.Status != http.StatusTooManyRequests)
+			h.done(failed, c.BreakerFailures, c.BreakerCooldown)
+		}
+
+		if !retry || attempt >= c.Retries {
+			return resp, err
+		}
+
+		delay := backoff
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+		if delay > 0 {
+			time.Sleep(delay + time.Duration(rand.Int63n(int64(delay)/4+1)))
+		}
+		backoff *= 2
+	}
+}
+
+// attempt performs a single request. retryAfter is the Retry-After header
+// value, if any.
+func (c *Client) attempt(h *host, method, urlStr string) (resp *Response, retryAfter time.Duration, err error) {
+	h.acquire(c.HostRate)
+	defer h.release()
+
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest(method, urlStr, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req = req.WithContext(ctx)
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	hResp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer hResp.Body.Close()
+
+	resp = &Response{
+		Status:      hResp.StatusCode,
+		ContentType: hResp.Header.Get("Content-Type"),
+		FinalURL:    hResp.Request.URL.String(),
+	}
+	if secs, err := strconv.Atoi(hResp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		retryAfter = time.Duration(secs) * time.Second
+	}
+	if method != "HEAD" {
+		if resp.Body, err = ioutil.ReadAll(hResp.Body); err != nil {
+			return nil, 0, err
+		}
+	}
+	return resp, retryAfter, nil
+}
+
+// failedHost returns the host of the request that caused the transport error
+// (it is the redirect target if the error happened after redirect) or empty
+// string if it is not known
+func failedHost(err error) string {
+	var uErr *url.Error
+	if err == nil || !stderrors.As(err, &uErr) {
+		return ""
+	}
+	u, pErr := url.Parse(uErr.URL)
+	if pErr != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// transient returns true if request can succeed on retry
+func transient(resp *Response, err error) bool {
+	if err != nil {
+		var dnsErr *net.DNSError
+		if stderrors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false
+		}
+		return true
+	}
+	return TransientStatus(resp.Status)
+}
+
+// TransientStatus returns true for the HTTP statuses of the temporary errors
+func TransientStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *Client) host(name string) *host {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hosts == nil {
+		c.hosts = make(map[string]*host)
+	}
+	h, ok := c.hosts[name]
+	if !ok {
+		h = new(host)
+		if c.HostConns > 0 {
+			h.conns = make(chan struct{}, c.HostConns)
+		}
+		c.hosts[name] = h
+	}
+	return h
+}
+
+// host holds the per-host limits and the circuit breaker state
+type host struct {
+	conns chan struct{} // semaphore of the parallel requests, nil if unlimited
+
+	mu        sync.Mutex
+	next      time.Time // time of the next allowed request
+	failures  int       // consecutive failures
+	openUntil time.Time // circuit is open until this time
+	probing   bool      // circuit is half-open, the probe request is running
+}
+
+// acquire waits for the free connection slot and for the rate limit
+func (h *host) acquire(rate float64) {
+	if h.conns != nil {
+		h.conns <- struct{}{}
+	}
+	if rate <= 0 {
+		return
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	h.mu.Lock()
+	now := time.Now()
+	wait := h.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	h.next = now.Add(wait + interval)
+	h.mu.Unlock()
+	time.Sleep(wait)
+}
+
+func (h *host) release() {
+	if h.conns != nil {
+		<-h.conns
+	}
+}
+
+// allow returns false if the circuit is open. After the cooldown a single
+// probe request is allowed.
+func (h *host) allow(maxFailures int) bool {
+	if maxFailures <= 0 {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failures < maxFailures {
+		return true
+	}
+	if h.probing || time.Now().Before(h.openUntil) {
+		return false
+	}
+	h.probing = true
+	return true
+}
+
+// done records the request result
+func (h *host) done(failed bool, maxFailures int, cooldown time.Duration) {
+	if maxFailures <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.probing = false
+	if !failed {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.failures >= maxFailures {
+		h.openUntil = time.Now().Add(cooldown)
+	}
+}
@@ -0,0 +1,198 @@
+package fetch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+func testClient(srv *httptest.Server, opts Options) *Client {
+	c := New(opts)
+	c.HTTP = srv.Client()
+	return c
+}
+
+func TestRetries(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		hits = make(map[string]int)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		if r.Header.Get("User-Agent") != "test-agent" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.URL.Path == "/flaky" && n < 3:
+			w.WriteHeader(http.StatusBadGateway)
+		case r.URL.Path == "/busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+
+	c := testClient(srv, Options{UserAgent: "test-agent", Timeout: time.Second, Retries: 3, Backoff: time.Millisecond})
+
+	for _, d := range []struct {
+		Path   string
+		Status int
+		Hits   int
+	}{
+		{"/flaky", 200, 3},
+		{"/busy", 503, 4},
+		{"/missing", 404, 1},
+	} {
+		resp, err := c.Get(srv.URL + d.Path)
+		if err != nil {
+			t.Fatalf("%s: %v", d.Path, err)
+		}
+		if resp.Status != d.Status || hits[d.Path] != d.Hits {
+			t.Errorf("%s: got status %d and %d hits, expects %d and %d", d.Path, resp.Status, hits[d.Path], d.Status, d.Hits)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := testClient(srv, Options{Timeout: time.Second, BreakerFailures: 2, BreakerCooldown: 50 * time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		if resp, err := c.Get(srv.URL); err != nil || resp.Status != 500 {
+			t.Fatalf("request %d: got %v, %v", i, resp, err)
+		}
+	}
+	if _, err := c.Get(srv.URL); errors.Cause(err) != ErrCircuitOpen {
+		t.Errorf("expects ErrCircuitOpen, got %v", err)
+	}
+	if hits != 2 {
+		t.Errorf("expects 2 hits, got %d", hits)
+	}
+
+	// Probe request after the cooldown
+	time.Sleep(60 * time.Millisecond)
+	if resp, err := c.Get(srv.URL); err != nil || resp.Status != 500 {
+		t.Errorf("probe request: got %v, %v", resp, err)
+	}
+	if _, err := c.Get(srv.URL); errors.Cause(err) != ErrCircuitOpen {
+		t.Errorf("expects ErrCircuitOpen after the failed probe, got %v", err)
+	}
+}
+
+func TestHostLimits(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		active, peak  int
+		first, latest time.Time
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		if first.IsZero() {
+			first = time.Now()
+		}
+		latest = time.Now()
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	c := testClient(srv, Options{Timeout: time.Second, HostConns: 2, HostRate: 100})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(srv.URL); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > 2 {
+		t.Errorf("expects at most 2 parallel requests, got %d", peak)
+	}
+	if d := latest.Sub(first); d < 45*time.Millisecond {
+		t.Errorf("6 requests at 100 rps are made in %s", d)
+	}
+}
+
+func TestCircuitBreakerDialErrors(t *testing.T) {
+	dials := 0
+	c := New(Options{Timeout: time.Second, Retries: 5, BreakerFailures: 3, BreakerCooldown: time.Minute})
+	c.HTTP = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials++
+			return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+		},
+	}}
+
+	// DNS errors are not retried, but they are the failures
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get("http://dead.example/img.jpg"); err == nil || errors.Cause(err) == ErrCircuitOpen {
+			t.Fatalf("request %d: expects dial error, got %v", i, err)
+		}
+	}
+	if _, err := c.Get("http://dead.example/other.jpg"); errors.Cause(err) != ErrCircuitOpen {
+		t.Errorf("expects ErrCircuitOpen, got %v", err)
+	}
+	if dials != 3 {
+		t.Errorf("expects 3 dials, got %d", dials)
+	}
+}
+
+func TestCircuitBreakerRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://dead.example"+r.URL.Path, http.StatusFound)
+	}))
+	defer srv.Close()
+
+	c := New(Options{Timeout: time.Second, BreakerFailures: 3, BreakerCooldown: time.Minute})
+	dialer := new(net.Dialer)
+	c.HTTP = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.HasPrefix(addr, "dead.example:") {
+				return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}}
+
+	// The redirect target fails, the redirecting host stays available
+	for i := 0; i < 5; i++ {
+		if _, err := c.Get(srv.URL + "/link"); err == nil || errors.Cause(err) == ErrCircuitOpen {
+			t.Fatalf("request %d: expects dial error, got %v", i, err)
+		}
+	}
+	if _, err := c.Get("http://dead.example/img.jpg"); errors.Cause(err) != ErrCircuitOpen {
+		t.Errorf("expects ErrCircuitOpen for the redirect target, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+// Package filetype detects the real type of the attached files by their
+// content (magic bytes) and maps it to the FreeFeed media type.
+package filetype
+
+import (
+	"archive/zip"
+	"bytes"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// FreeFeed media types
+const (
+	Image   = "image"
+	Audio   = "audio"
+	Video   = "video"
+	General = "general"
+)
+
+// Type is a detected file type
+type Type struct {
+	MIMEType  string
+	Ext       string // file extension without dot, may be empty
+	MediaType string
+}
+
+// knownExtensions are extensions that can be taken from the file name if the
+// type cannot be detected by content
+var knownExtensions = map[string]Type{
+	"jpg":  {"image/jpeg", "jpg", General},
+	"jpeg": {"image/jpeg", "jpeg", General},
+	"png":  {"image/png", "png", General},
+	"gif":  {"image/gif", "gif", General},
+	"mp3":  {"audio/mpeg", "mp3", Audio},
+	"m4a":  {"audio/mp4", "m4a", Audio},
+	"ogg":  {"audio/ogg", "ogg", Audio},
+	"wav":  {"audio/x-wav", "wav", Audio},
+	"mp4":  {"video/mp4", "mp4", Video},
+	"mov":  {"video/quicktime", "mov", Video},
+	"flv":  {"video/x-flv", "flv", Video},
+	"txt":  {"text/plain", "txt", General},
+	"pdf":  {"application/pdf", "pdf", General},
+	"rtf":  {"application/rtf", "rtf", General},
+	"zip":  {"application/zip", "zip", General},
+	"rar":  {"application/vnd.rar", "rar", General},
+	"doc":  {"application/msword", "doc", General},
+	"xls":  {"application/vnd.ms-excel", "xls", General},
+	"ppt":  {"application/vnd.ms-powerpoint", "ppt", General},
+	"docx": {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "docx", General},
+	"xlsx": {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx", General},
+	"pptx": {"application/vnd.openxmlformats-officedocument.presentationml.presentation", "pptx", General},
+	"odt":  {"application/vnd.oasis.opendocument.text", "odt", General},
+	"ods":  {"application/vnd.oasis.opendocument.spreadsheet", "ods", General},
+	"odp":  {"application/vnd.oasis.opendocument.presentation", "odp", General},
+}
+
+// audioTypes are the declared MIME types of the audio files in archives
+var audioTypes = map[string]string{
+	"audio/mpeg":  "mp3",
+	"audio/x-m4a": "m4a",
+	"audio/mp4":   "m4a",
+	"audio/ogg":   "ogg",
+	"audio/x-wav": "wav",
+}
+
+// Detect returns the type of file. The content is checked first, then the
+// file name extension and then the declared MIME type. The text content is
+// the last resort, so html, csv and other text formats keep their types.
+func Detect(body []byte, name, declared string) Type {
+	if t, ok := sniff(body); ok {
+		return t
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if t, ok := knownExtensions[ext]; ok {
+		return t
+	}
+	if ext, ok := audioTypes[declared]; ok {
+		return knownExtensions[ext]
+	}
+	if declared != "" && declared != "application/octet-stream" {
+		return Type{MIMEType: declared, MediaType: General}
+	}
+	if isText(body) {
+		return knownExtensions["txt"]
+	}
+	return Type{MIMEType: "application/octet-stream", MediaType: General}
+}
+
+func ext(e string) (Type, bool) { return knownExtensions[e], true }
+
+func sniff(b []byte) (Type, bool) {
+	has := func(off int, sig string) bool {
+		return len(b) >= off+len(sig) && string(b[off:off+len(sig)]) == sig
+	}
+	switch {
+	case has(0, "\xFF\xD8\xFF"):
+		return ext("jpg")
+	case has(0, "\x89PNG\r\n\x1a\n"):
+		return ext("png")
+	case has(0, "GIF87a"), has(0, "GIF89a"):
+		return ext("gif")
+	case has(0, "ID3"), len(b) >= 2 && b[0] == 0xFF && b[1]&0xE6 == 0xE2: // MPEG audio layer III
+		return ext("mp3")
+	case has(0, "OggS"):
+		if bytes.Contains(b[:min(len(b), 128)], []byte("theora")) {
+			return Type{"video/ogg", "ogv", Video}, true
+		}
+		return ext("ogg")
+	case has(0, "RIFF") && has(8, "WAVE"):
+		return ext("wav")
+	case has(0, "RIFF") && has(8, "AVI "):
+		return Type{"video/x-msvideo", "avi", Video}, true
+	case has(4, "ftyp"):
+		return sniffFtyp(b)
+	case has(0, "FLV\x01"):
+		return ext("flv")
+	case has(0, "%PDF-"):
+		return ext("pdf")
+	case has(0, "{\\rtf"):
+		return ext("rtf")
+	case has(0, "Rar!\x1a\x07"):
+		return ext("rar")
+	case has(0, "PK\x03\x04"):
+		return sniffZip(b)
+	case has(0, "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"):
+		// MS Office 97-2003 documents cannot be distinguished by
+		// signature, the file name extension is used
+		return Type{}, false
+	}
+	return Type{}, false
+}
+
+// sniffFtyp detects ISO base media (MP4, M4A, MOV) files by their major brand
+func sniffFtyp(b []byte) (Type, bool) {
+	if len(b) < 12 {
+		return Type{}, false
+	}
+	switch brand := string(b[8:12]); {
+	case brand == "M4A " || brand == "M4B ":
+		return ext("m4a")
+	case brand == "qt  ":
+		return ext("mov")
+	case strings.HasPrefix(brand, "3g"):
+		return Type{"video/3gpp", "3gp", Video}, true
+	}
+	return ext("mp4")
+}
+
+// sniffZip detects ZIP based document formats by their content
+func sniffZip(b []byte) (Type, bool) {
+	// OpenDocument files begin with the uncompressed "mimetype" file
+	if len(b) > 38 && string(b[30:38]) == "mimetype" {
+		content := string(b[38:min(len(b), 38+80)])
+		for _, e := range []string{"odt", "ods", "odp"} {
+			t := knownExtensions[e]
+			if strings.HasPrefix(content, t.MIMEType) {
+				return t, true
+			}
+		}
+	}
+	if r, err := zip.NewReader(bytes.NewReader(b), int64(len(b))); err == nil {
+		for _, f := range r.File {
+			switch {
+			case strings.HasPrefix(f.Name, "word/"):
+				return ext("docx")
+			case strings.HasPrefix(f.Name, "xl/"):
+				return ext("xlsx")
+			case strings.HasPrefix(f.Name, "ppt/"):
+				return ext("pptx")
+			}
+		}
+	}
+	return ext("zip")
+}
+
+// isText returns true if b looks like an UTF-8 text
+func isText(b []byte) bool {
+	if len(b) == 0 || bytes.IndexByte(b, 0) >= 0 {
+		return false
+	}
+	if len(b) > 1024 {
+		b = b[:1024]
+		// do not break the last rune
+		for i := 0; i < utf8.UTFMax && !utf8.Valid(b); i++ {
+			b = b[:len(b)-1]
+		}
+	}
+	return utf8.Valid(b)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,77 @@
+package filetype
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+func zipFile(t *testing.T, names ...string) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("content"))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func odfFile(t *testing.T, mimeType string) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	f, err := w.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(mimeType))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+	for _, d := range []struct {
+		Body     []byte
+		Name     string
+		Declared string
+		Result   Type
+	}{
+		{[]byte("ID3\x03\x00\x00\x00"), "song", "", Type{"audio/mpeg", "mp3", Audio}},
+		{[]byte("\xFF\xFB\x90\x00"), "song.bin", "application/octet-stream", Type{"audio/mpeg", "mp3", Audio}},
+		{[]byte("\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00"), "song", "audio/mpeg", Type{"audio/mp4", "m4a", Audio}},
+		{[]byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00"), "video", "", Type{"video/mp4", "mp4", Video}},
+		{[]byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00"), "video", "", Type{"video/quicktime", "mov", Video}},
+		{[]byte("FLV\x01\x05"), "clip", "", Type{"video/x-flv", "flv", Video}},
+		{[]byte("OggS\x00\x02\x00\x00\x01vorbis"), "song", "", Type{"audio/ogg", "ogg", Audio}},
+		{[]byte("RIFF\x00\x00\x00\x00WAVEfmt "), "sound", "", Type{"audio/x-wav", "wav", Audio}},
+		{[]byte("%PDF-1.4"), "doc", "text/plain", Type{"application/pdf", "pdf", General}},
+		{[]byte("{\\rtf1\\ansi"), "doc.doc", "application/msword", Type{"application/rtf", "rtf", General}},
+		{[]byte("Rar!\x1a\x07\x00"), "files", "", Type{"application/vnd.rar", "rar", General}},
+		{zipFile(t, "a.txt"), "files", "", Type{"application/zip", "zip", General}},
+		{zipFile(t, "[Content_Types].xml", "word/document.xml"), "doc", "application/zip", knownExtensions["docx"]},
+		{zipFile(t, "[Content_Types].xml", "xl/workbook.xml"), "table", "", knownExtensions["xlsx"]},
+		{odfFile(t, "application/vnd.oasis.opendocument.text"), "doc", "", knownExtensions["odt"]},
+		{odfFile(t, "application/vnd.oasis.opendocument.spreadsheet"), "table", "", knownExtensions["ods"]},
+		{[]byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), "table.XLS", "", knownExtensions["xls"]},
+		{[]byte("Hello, мир!"), "readme", "", Type{"text/plain", "txt", General}},
+		{[]byte("Hello, мир!"), "readme", "application/octet-stream", Type{"text/plain", "txt", General}},
+		{[]byte("<html></html>"), "page.html", "text/html", Type{"text/html", "", General}},
+		{[]byte("a,b\n1,2\n"), "table", "text/csv", Type{"text/csv", "", General}},
+		{[]byte("Hello"), "notes.txt", "text/html", Type{"text/plain", "txt", General}},
+		{[]byte("\x00\x01\x02"), "data.ogg", "", Type{"audio/ogg", "ogg", Audio}},
+		{[]byte("\x00\x01\x02"), "data", "audio/x-m4a", Type{"audio/mp4", "m4a", Audio}},
+		{[]byte("\x00\x01\x02"), "data.bin", "application/x-foo", Type{"application/x-foo", "", General}},
+		{[]byte("\x00\x01\x02"), "data", "", Type{"application/octet-stream", "", General}},
+	} {
+		if res := Detect(d.Body, d.Name, d.Declared); res != d.Result {
+			t.Errorf("Detect %q (%q, %q): got %v, expects %v", d.Body[:min(len(d.Body), 16)], d.Name, d.Declared, res, d.Result)
+		}
+	}
+}
@@ -3,17 +3,31 @@ package hashtags
 import (
 	"database/sql"
 	"strings"
+	"sync"
 
 	"github.com/FreeFeed/clio-restore/internal/dbutil"
 	"github.com/davidmz/mustbe"
 )
 
-var cache = make(map[string]int)
+var (
+	cache   = make(map[string]int)
+	cacheMu sync.Mutex
+)
+
+// ResetCache forgets the cached hashtag IDs
+func ResetCache() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cache = make(map[string]int)
+}
 
-// GetID returns ID of given hashtag
+// GetID returns ID of given hashtag. GetID is safe for concurrent use.
 func GetID(db dbutil.QueryRower, hashtag string) int {
 	hashtag = strings.ToLower(hashtag)
 
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
 	if id, ok := cache[hashtag]; ok {
 		return id
 	}
@@ -0,0 +1,66 @@
+package imgproc
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/juju/errors"
+)
+
+// GM is a processor that uses GraphicsMagick and gifsicle
+type GM struct {
+	GM       string // path to the gm executable
+	GifSicle string // path to the gifsicle executable
+	SRGB     string // path to the sRGB ICM profile
+}
+
+// AutoOrient implements Processor
+func (p *GM) AutoOrient(body []byte, orient int) ([]byte, error) {
+	return run(body, exec.Command(p.GM,
+		"convert",
+		"-", // stdin
+		"-profile", p.SRGB,
+		"-auto-orient",
+		"-quality", strconv.Itoa(DefaultQuality),
+		"jpeg:-", // stdout
+	))
+}
+
+// Resize implements Processor. GIF images are resized by gifsicle, other
+// formats (including WebP, if gm supports it) are encoded by gm.
+func (p *GM) Resize(body []byte, opts Options) ([]byte, error) {
+	if opts.Format == "gif" {
+		return run(body, exec.Command(p.GifSicle,
+			"--resize", fmt.Sprintf("%dx%d", opts.Width, opts.Height),
+			"-O3",
+		))
+	}
+	return run(body, exec.Command(p.GM,
+		"convert",
+		"-", // stdin
+		"-resize", fmt.Sprintf("%dx%d!", opts.Width, opts.Height),
+		"-profile", p.SRGB,
+		"-auto-orient",
+		"-quality", strconv.Itoa(opts.quality()),
+		opts.Format+":-", // stdout
+	))
+}
+
+func run(body []byte, cmd *exec.Cmd) ([]byte, error) {
+	cmd.Stdin = bytes.NewReader(body)
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if out.Len() == 0 {
+		return nil, errors.New("empty result")
+	}
+	return out.Bytes(), nil
+}
+
+// Encodes implements Processor. The supported formats depend on the gm build,
+// so all formats are assumed to be supported.
+func (p *GM) Encodes(format string) bool { return true }
@@ -0,0 +1,85 @@
+// Package imgproc performs image operations required to create attachments:
+// auto-orientation of JPEG images and resizing of JPEG, PNG and GIF images.
+// There are two implementations: GM uses the external GraphicsMagick and
+// gifsicle programs, Native is a pure-Go implementation.
+package imgproc
+
+import (
+	"bytes"
+
+	"github.com/juju/errors"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Processor performs image operations. Formats are the image.DecodeConfig
+// format names: "jpeg", "png", "gif" and others.
+type Processor interface {
+	// AutoOrient rotates JPEG image according to its EXIF orientation
+	AutoOrient(body []byte, orient int) ([]byte, error)
+	// Resize resizes image to exactly opts.Width x opts.Height pixels and
+	// encodes it to opts.Format. Resize to the same size converts image.
+	Resize(body []byte, opts Options) ([]byte, error)
+	// Encodes returns true if processor can encode images to the given format
+	Encodes(format string) bool
+}
+
+// Options are the output image options
+type Options struct {
+	Format  string
+	Width   int
+	Height  int
+	Quality int // quality of the lossy formats, 0 means DefaultQuality
+}
+
+// DefaultQuality is a default quality of the lossy formats
+const DefaultQuality = 95
+
+func (o Options) quality() int {
+	if o.Quality == 0 {
+		return DefaultQuality
+	}
+	return o.Quality
+}
+
+// Processor types
+const (
+	TypeGM     = "gm"
+	TypeNative = "native"
+)
+
+// New creates processor of the given type. If sType is empty, GM is used
+// when the gm path is defined and Native otherwise.
+func New(sType, gm, gifSicle, sRGB string) (Processor, error) {
+	if sType == "" {
+		if gm != "" {
+			sType = TypeGM
+		} else {
+			sType = TypeNative
+		}
+	}
+	switch sType {
+	case TypeGM:
+		if gm == "" || gifSicle == "" {
+			return nil, errors.New("GM and GifSicle are required for the gm image processor")
+		}
+		return &GM{GM: gm, GifSicle: gifSicle, SRGB: sRGB}, nil
+	case TypeNative:
+		return new(Native), nil
+	}
+	return nil, errors.Errorf("unknown image processor: %q", sType)
+}
+
+// Orientation returns the EXIF orientation of JPEG image (1 to 8) or 0 if
+// image has no orientation tag
+func Orientation(body []byte) (orient int) {
+	x, err := exif.Decode(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	oTag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return
+	}
+	orient, _ = oTag.Int(0)
+	return
+}
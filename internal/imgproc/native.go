@@ -0,0 +1,124 @@
+package imgproc
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/juju/errors"
+	_ "golang.org/x/image/bmp" // register decoders of the source formats
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Native is a pure-Go processor. It uses the Lanczos resampling and doesn't
+// convert images to sRGB (the embedded color profiles are dropped). It can
+// encode only JPEG, PNG and GIF images.
+type Native struct{}
+
+// AutoOrient implements Processor
+func (p *Native) AutoOrient(body []byte, orient int) ([]byte, error) {
+	if orient < 2 || orient > 8 {
+		return body, nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot decode image")
+	}
+	return encode(orientImage(img, orient), Options{Format: "jpeg"})
+}
+
+// Resize implements Processor
+func (p *Native) Resize(body []byte, opts Options) ([]byte, error) {
+	img, format, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot decode image")
+	}
+	if format == "gif" && opts.Format == "gif" {
+		return resizeGIF(body, opts.Width, opts.Height)
+	}
+	return encode(resize(img, opts.Width, opts.Height), opts)
+}
+
+// Encodes implements Processor
+func (p *Native) Encodes(format string) bool {
+	return format == "jpeg" || format == "png" || format == "gif"
+}
+
+func encode(img image.Image, opts Options) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	var err error
+	switch opts.Format {
+	case "jpeg":
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: opts.quality()})
+	case "png":
+		err = png.Encode(buf, img)
+	case "gif":
+		err = gif.Encode(buf, img, nil)
+	default:
+		err = errors.Errorf("format %q is not supported by the native image processor", opts.Format)
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot encode image")
+	}
+	return buf.Bytes(), nil
+}
+
+// resizeGIF resizes all frames of the (animated) GIF. Frames are composed
+// onto the full canvas before resizing, so the resized frames are full-size.
+func resizeGIF(body []byte, width, height int) ([]byte, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot decode image")
+	}
+	if len(g.Image) == 0 {
+		return nil, errors.New("GIF has no frames")
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(bounds)
+	out := &gif.GIF{
+		Delay:     g.Delay,
+		LoopCount: g.LoopCount,
+		Config:    image.Config{Width: width, Height: height},
+	}
+
+	for i, frame := range g.Image {
+		var saved *image.RGBA
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			saved = image.NewRGBA(bounds)
+			draw.Draw(saved, bounds, canvas, bounds.Min, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		resized := resize(canvas, width, height)
+		pal := image.NewPaletted(resized.Bounds(), frame.Palette)
+		draw.FloydSteinberg.Draw(pal, pal.Bounds(), resized, image.Point{})
+		out.Image = append(out.Image, pal)
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = saved
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, out); err != nil {
+		return nil, errors.Annotate(err, "cannot encode image")
+	}
+	return buf.Bytes(), nil
+}
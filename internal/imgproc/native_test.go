@@ -0,0 +1,139 @@
+package imgproc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+var (
+	red  = color.RGBA{255, 0, 0, 255}
+	blue = color.RGBA{0, 0, 255, 255}
+)
+
+// testImage returns w x h image with the red left half and the blue right half
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	return img
+}
+
+func TestResize(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, testImage(100, 40)); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := new(Native).Resize(buf.Bytes(), Options{Format: "png", Width: 25, Height: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || img.Bounds().Dx() != 25 || img.Bounds().Dy() != 10 {
+		t.Fatalf("Resize: got %s %v", format, img.Bounds())
+	}
+	if c := color.RGBAModel.Convert(img.At(2, 5)); c != red {
+		t.Errorf("Resize: expects red at the left, got %v", c)
+	}
+	if c := color.RGBAModel.Convert(img.At(22, 5)); c != blue {
+		t.Errorf("Resize: expects blue at the right, got %v", c)
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	src := testImage(4, 2)
+	for _, d := range []struct {
+		Orient  int
+		W, H    int
+		TopLeft color.RGBA
+	}{
+		{1, 4, 2, red},
+		{2, 4, 2, blue},
+		{3, 4, 2, blue},
+		{4, 4, 2, red},
+		{5, 2, 4, red},
+		{6, 2, 4, red},
+		{7, 2, 4, blue},
+		{8, 2, 4, blue},
+	} {
+		img := orientImage(src, d.Orient)
+		if img.Rect.Dx() != d.W || img.Rect.Dy() != d.H {
+			t.Errorf("Orientation %d: got size %v, expects %dx%d", d.Orient, img.Rect, d.W, d.H)
+			continue
+		}
+		if c := img.RGBAAt(0, 0); c != d.TopLeft {
+			t.Errorf("Orientation %d: got %v at top left, expects %v", d.Orient, c, d.TopLeft)
+		}
+	}
+}
+
+func TestResizeGIF(t *testing.T) {
+	g := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 60, 30), palette.Plan9)
+		for y := 0; y < 30; y++ {
+			for x := 0; x < 60; x++ {
+				frame.Set(x, y, red)
+			}
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := new(Native).Resize(buf.Bytes(), Options{Format: "gif", Width: 20, Height: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := gif.DecodeAll(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Image) != 3 || res.Config.Width != 20 || res.Config.Height != 10 {
+		t.Errorf("ResizeGIF: got %d frames of %dx%d", len(res.Image), res.Config.Width, res.Config.Height)
+	}
+}
+
+func TestResizeUnsupportedFormat(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, testImage(10, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := new(Native).Resize(buf.Bytes(), Options{Format: "webp", Width: 10, Height: 10}); err == nil {
+		t.Error("Resize to WebP: expects error")
+	}
+}
+
+func TestConvertBMP(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := bmp.Encode(buf, testImage(10, 10)); err != nil {
+		t.Fatal(err)
+	}
+	body, err := new(Native).Resize(buf.Bytes(), Options{Format: "jpeg", Width: 10, Height: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(body)); err != nil || format != "jpeg" {
+		t.Errorf("Convert BMP: got %q, %v", format, err)
+	}
+}
@@ -0,0 +1,171 @@
+package imgproc
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// lanczos is a Lanczos kernel with a = 3
+func lanczos(x float64) float64 {
+	const a = 3
+	if x == 0 {
+		return 1
+	}
+	if x <= -a || x >= a {
+		return 0
+	}
+	px := math.Pi * x
+	return a * math.Sin(px) * math.Sin(px/a) / (px * px)
+}
+
+// contrib is a set of source pixels weights for the one destination pixel
+type contrib struct {
+	start   int
+	weights []float64
+}
+
+func contribs(dstSize, srcSize int) []contrib {
+	scale := float64(srcSize) / float64(dstSize)
+	filterScale := math.Max(scale, 1) // widen kernel when downscaling
+	support := 3 * filterScale
+
+	res := make([]contrib, dstSize)
+	for i := range res {
+		center := (float64(i) + 0.5) * scale
+		start := int(math.Floor(center - support))
+		if start < 0 {
+			start = 0
+		}
+		end := int(math.Ceil(center + support))
+		if end > srcSize {
+			end = srcSize
+		}
+		var (
+			weights = make([]float64, end-start)
+			sum     float64
+		)
+		for j := range weights {
+			w := lanczos((float64(start+j) + 0.5 - center) / filterScale)
+			weights[j] = w
+			sum += w
+		}
+		if sum != 0 {
+			for j := range weights {
+				weights[j] /= sum
+			}
+		}
+		res[i] = contrib{start, weights}
+	}
+	return res
+}
+
+// resize resizes img to exactly width x height using Lanczos resampling
+func resize(img image.Image, width, height int) *image.RGBA {
+	src := toRGBA(img)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+
+	// Horizontal pass: sw x sh -> width x sh
+	tmp := make([]float64, width*sh*4)
+	for x, c := range contribs(width, sw) {
+		for y := 0; y < sh; y++ {
+			var r, g, b, a float64
+			p := y*src.Stride + c.start*4
+			for _, w := range c.weights {
+				r += w * float64(src.Pix[p])
+				g += w * float64(src.Pix[p+1])
+				b += w * float64(src.Pix[p+2])
+				a += w * float64(src.Pix[p+3])
+				p += 4
+			}
+			t := (y*width + x) * 4
+			tmp[t], tmp[t+1], tmp[t+2], tmp[t+3] = r, g, b, a
+		}
+	}
+
+	// Vertical pass: width x sh -> width x height
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, c := range contribs(height, sh) {
+		for x := 0; x < width; x++ {
+			var r, g, b, a float64
+			t := (c.start*width + x) * 4
+			for _, w := range c.weights {
+				r += w * tmp[t]
+				g += w * tmp[t+1]
+				b += w * tmp[t+2]
+				a += w * tmp[t+3]
+				t += width * 4
+			}
+			// Keep premultiplied colors valid: color <= alpha
+			a = clamp(a)
+			p := y*dst.Stride + x*4
+			dst.Pix[p] = uint8(math.Min(clamp(r), a))
+			dst.Pix[p+1] = uint8(math.Min(clamp(g), a))
+			dst.Pix[p+2] = uint8(math.Min(clamp(b), a))
+			dst.Pix[p+3] = uint8(a)
+		}
+	}
+	return dst
+}
+
+func clamp(v float64) float64 {
+	v = math.Floor(v + 0.5)
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// toRGBA converts image to *image.RGBA with (0, 0) origin
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok && b.Min == (image.Point{}) {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// orientImage transforms image according to the EXIF orientation
+func orientImage(img image.Image, orient int) *image.RGBA {
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	// srcPoint returns the source coordinates of the destination pixel
+	var srcPoint func(x, y int) (int, int)
+	dw, dh := w, h
+	switch orient {
+	case 2: // flip horizontal
+		srcPoint = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // rotate 180
+		srcPoint = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // flip vertical
+		srcPoint = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // transpose
+		srcPoint = func(x, y int) (int, int) { return y, x }
+	case 6: // rotate 90 CW
+		srcPoint = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // transverse
+		srcPoint = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // rotate 90 CCW
+		srcPoint = func(x, y int) (int, int) { return w - 1 - y, x }
+	default:
+		return src
+	}
+	if orient >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := srcPoint(x, y)
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Log writes records of the given level. It has the log.Logger-like
+// interface, so it can replace the old *log.Logger globals.
+type Log struct {
+	Level  slog.Level
+	Logger *slog.Logger // if nil, the Default() logger is used
+}
+
+// Loggers of the current output
+var (
+	Debug = &Log{Level: slog.LevelDebug}
+	Info  = &Log{Level: slog.LevelInfo}
+	Error = &Log{Level: slog.LevelError}
+	Fatal = &Log{Level: LevelFatal}
+)
+
+// For returns the Log of the same level writing to the logger
+func (l *Log) For(logger *slog.Logger) *Log { return &Log{Level: l.Level, Logger: logger} }
+
+// With returns the Log with the given fields (key-value pairs) added
+func (l *Log) With(args ...interface{}) *Log { return l.For(l.logger().With(args...)) }
+
+// Print prints message like fmt.Print
+func (l *Log) Print(v ...interface{}) { l.log(fmt.Sprint(v...)) }
+
+// Printf prints message like fmt.Printf
+func (l *Log) Printf(format string, v ...interface{}) { l.log(fmt.Sprintf(format, v...)) }
+
+// Println prints message like fmt.Println
+func (l *Log) Println(v ...interface{}) { l.log(strings.TrimSuffix(fmt.Sprintln(v...), "\n")) }
+
+// Fatalf prints message like fmt.Printf and exits with code 1
+func (l *Log) Fatalf(format string, v ...interface{}) {
+	l.Printf(format, v...)
+	os.Exit(1)
+}
+
+func (l *Log) log(msg string) { l.logger().Log(context.Background(), l.Level, msg) }
+
+func (l *Log) logger() *slog.Logger {
+	if l.Logger != nil {
+		return l.Logger
+	}
+	return Default()
+}
+
+// textHandler writes records in the traditional format of the clio tools:
+// "INFO  2006/01/02 15:04:05 message key=value".
+type textHandler struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string // group prefix of the attribute keys
+}
+
+func (h *textHandler) Enabled(_ context.Context, l slog.Level) bool { return l >= h.level.Level() }
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := new(strings.Builder)
+	fmt.Fprintf(buf, "%-6s%s %s", levelName(r.Level), r.Time.Format("2006/01/02 15:04:05"), r.Message)
+	for _, a := range h.attrs {
+		writeAttr(buf, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(buf, h.prefix, a)
+		return true
+	})
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, buf.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = append(h2.attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &h2
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+func writeAttr(buf *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			writeAttr(buf, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	val := a.Value.String()
+	if val == "" || strings.ContainsAny(val, " \t\n\"=") {
+		val = strconv.Quote(val)
+	}
+	fmt.Fprintf(buf, " %s%s=%s", prefix, a.Key, val)
+}
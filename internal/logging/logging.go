@@ -0,0 +1,205 @@
+// Package logging is the common leveled logger of the clio tools. Logs can be
+// written as text, logfmt or JSON, with the context fields (tool, archive
+// owner, entry etc.) attached to every record.
+//
+// The package defines the -log-format, -log-level and -log-dir command line
+// flags, Setup must be called after flag.Parse.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// LevelFatal is the level of the errors that stop the program
+const LevelFatal = slog.LevelError + 4
+
+// Formats of the log output
+const (
+	FormatText   = "text"
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+var (
+	formatFlag string
+	levelFlag  string
+	dirFlag    string
+)
+
+func init() {
+	flag.StringVar(&formatFlag, "log-format", FormatText, "log format: text, logfmt or json")
+	flag.StringVar(&levelFlag, "log-level", "info", "minimal log level: debug, info, warn or error")
+	flag.StringVar(&dirFlag, "log-dir", "", "directory to write the log file of each run to")
+}
+
+// Current logging state
+var (
+	mu      sync.Mutex
+	tool    string
+	format            = FormatText
+	level             = new(slog.LevelVar)
+	base    io.Writer = os.Stdout // output and the run log file
+	tee     io.Writer             // additional output
+	fields  []interface{}
+	logger  = newLogger(os.Stdout)
+	runFile *os.File
+)
+
+// Setup configures logging of the tool by the command line flags. Logs are
+// written to w and (if -log-dir is set) to the run log file. The standard log
+// output is written as the debug records.
+func Setup(toolName string, w io.Writer) error {
+	if err := configure(toolName, w, formatFlag, levelFlag); err != nil {
+		return err
+	}
+	log.SetFlags(0)
+	log.SetOutput(stdLog{})
+	if dirFlag == "" {
+		return nil
+	}
+
+	fileName := filepath.Join(dirFlag, fmt.Sprintf(
+		"%s-%s-%d.log", toolName, time.Now().Format("20060102-150405"), os.Getpid(),
+	))
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Annotate(err, "cannot create log file")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	runFile = f
+	base = io.MultiWriter(w, f)
+	update()
+	return nil
+}
+
+// stdLog writes the standard log output (like the net/http messages) as the
+// debug records
+type stdLog struct{}
+
+func (stdLog) Write(p []byte) (int, error) {
+	Debug.Print(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// Close closes the run log file
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+	if runFile != nil {
+		runFile.Close()
+		runFile = nil
+	}
+}
+
+func configure(toolName string, w io.Writer, fmtName, levelName string) error {
+	switch fmtName {
+	case FormatText, FormatLogfmt, FormatJSON:
+	default:
+		return errors.Errorf("unknown log format %q", fmtName)
+	}
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(levelName)); err != nil {
+		return errors.Errorf("unknown log level %q", levelName)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	tool, format, base, fields = toolName, fmtName, w, nil
+	level.Set(lvl)
+	update()
+	return nil
+}
+
+// SetContext replaces the context fields (key-value pairs) of all log
+// records. Call it without arguments to clear the fields.
+func SetContext(args ...interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	fields = args
+	update()
+}
+
+// Tee sets the additional output of the log records, nil removes it
+func Tee(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	tee = w
+	update()
+}
+
+// Output returns the current log output
+func Output() io.Writer {
+	mu.Lock()
+	defer mu.Unlock()
+	return output()
+}
+
+// NewLogger returns a logger with the current format, level and fields
+// writing to w
+func NewLogger(w io.Writer) *slog.Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	return newLogger(w)
+}
+
+// Default returns the logger writing to the current output
+func Default() *slog.Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	return logger
+}
+
+func output() io.Writer {
+	if tee != nil {
+		return io.MultiWriter(base, tee)
+	}
+	return base
+}
+
+func update() { logger = newLogger(output()) }
+
+func newLogger(w io.Writer) *slog.Logger {
+	var h slog.Handler
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel}
+	switch format {
+	case FormatLogfmt:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		h = &textHandler{w: w, mu: new(sync.Mutex), level: level}
+	}
+	l := slog.New(h)
+	if tool != "" && format != FormatText {
+		// Text logs are read by humans, they know what program they run
+		l = l.With("tool", tool)
+	}
+	return l.With(fields...)
+}
+
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		a.Value = slog.StringValue(levelName(a.Value.Any().(slog.Level)))
+	}
+	return a
+}
+
+func levelName(l slog.Level) string {
+	if l == LevelFatal {
+		return "FATAL"
+	}
+	return strings.SplitN(l.String(), "+", 2)[0]
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	for _, d := range []struct {
+		Format   string
+		Level    string
+		Expected string // regexp
+	}{
+		{
+			FormatText, "info",
+			`^INFO  \d{4}/\d\d/\d\d \d\d:\d\d:\d\d message 1 owner=alice url="http://x/\?a=b"\n` +
+				`ERROR \d{4}/\d\d/\d\d \d\d:\d\d:\d\d error owner=alice\n` +
+				`FATAL \d{4}/\d\d/\d\d \d\d:\d\d:\d\d fatal owner=alice\n$`,
+		},
+		{
+			FormatText, "error",
+			`^ERROR \S+ \S+ error owner=alice\nFATAL \S+ \S+ fatal owner=alice\n$`,
+		},
+		{
+			FormatLogfmt, "info",
+			`^time=\S+ level=INFO msg="message 1" tool=test owner=alice url="http://x/\?a=b"\n` +
+				`time=\S+ level=ERROR msg=error tool=test owner=alice\n` +
+				`time=\S+ level=FATAL msg=fatal tool=test owner=alice\n$`,
+		},
+		{
+			FormatJSON, "debug",
+			`^\{"time":"\S+","level":"DEBUG","msg":"debug","tool":"test","owner":"alice"\}\n` +
+				`\{"time":"\S+","level":"INFO","msg":"message 1","tool":"test","owner":"alice","url":"http://x/\?a=b"\}\n` +
+				`\{"time":"\S+","level":"ERROR","msg":"error","tool":"test","owner":"alice"\}\n` +
+				`\{"time":"\S+","level":"FATAL","msg":"fatal","tool":"test","owner":"alice"\}\n$`,
+		},
+	} {
+		buf := new(bytes.Buffer)
+		if err := configure("test", buf, d.Format, d.Level); err != nil {
+			t.Fatal(err)
+		}
+		SetContext("owner", "alice")
+		Debug.Println("debug")
+		Info.With("url", "http://x/?a=b").Println("message", 1)
+		Error.Printf("%s", "error")
+		Fatal.Print("fatal")
+
+		if !regexp.MustCompile(d.Expected).MatchString(buf.String()) {
+			t.Errorf("%s/%s: unexpected output:\n%s", d.Format, d.Level, buf.String())
+		}
+	}
+}
+
+func TestTee(t *testing.T) {
+	buf, teeBuf := new(bytes.Buffer), new(bytes.Buffer)
+	if err := configure("test", buf, FormatLogfmt, "info"); err != nil {
+		t.Fatal(err)
+	}
+
+	job := Info.For(NewLogger(teeBuf)).With("entry", "e1")
+	Tee(teeBuf)
+	Info.Println("both")
+	Tee(nil)
+	Info.Println("main")
+	job.Println("job")
+
+	if expected := regexp.MustCompile(`^\S+ level=INFO msg=both tool=test\n\S+ level=INFO msg=main tool=test\n$`); !expected.MatchString(buf.String()) {
+		t.Errorf("unexpected main output:\n%s", buf.String())
+	}
+	if expected := regexp.MustCompile(`^\S+ level=INFO msg=both tool=test\n\S+ level=INFO msg=job tool=test entry=e1\n$`); !expected.MatchString(teeBuf.String()) {
+		t.Errorf("unexpected tee output:\n%s", teeBuf.String())
+	}
+}
+
+func TestConfigureErrors(t *testing.T) {
+	if err := configure("test", new(bytes.Buffer), "xml", "info"); err == nil {
+		t.Error("unknown format is accepted")
+	}
+	if err := configure("test", new(bytes.Buffer), FormatText, "verbose"); err == nil {
+		t.Error("unknown level is accepted")
+	}
+}
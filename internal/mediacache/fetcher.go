@@ -0,0 +1,105 @@
+package mediacache
+
+import (
+	"time"
+
+	"github.com/FreeFeed/clio-restore/internal/fetch"
+	"github.com/juju/errors"
+)
+
+// ErrNotCached is returned by the offline Fetcher for requests that are not
+// in the cache
+var ErrNotCached = errors.New("not in the media cache")
+
+// Response is a fetched (or cached) response
+type Response struct {
+	*Record
+	Body   []byte
+	Cached bool // response is taken from the cache
+}
+
+// DefaultErrorTTL is the default Fetcher.ErrorTTL
+const DefaultErrorTTL = 7 * 24 * time.Hour
+
+// Fetcher fetches remote URLs through the cache. Once fetched, the successful
+// response is always taken from the cache, so the repeated fetches give the
+// same result. Network errors, the temporary errors (429 and 5xx responses)
+// and the error responses older than ErrorTTL are cached too, but the online
+// Fetcher refetches such requests.
+type Fetcher struct {
+	Cache    *Cache        // can be nil, then responses are not cached
+	Client   *fetch.Client // fetch.Default if nil
+	Offline  bool          // do not use network, take responses only from the cache
+	ErrorTTL time.Duration // DefaultErrorTTL if zero
+}
+
+// Get performs (or takes from the cache) the GET request
+func (f *Fetcher) Get(url string) (*Response, error) { return f.do("GET", url) }
+
+// Head performs (or takes from the cache) the HEAD request
+func (f *Fetcher) Head(url string) (*Response, error) { return f.do("HEAD", url) }
+
+// FinalURL returns the URL after all redirects using the HEAD request
+func (f *Fetcher) FinalURL(url string) (string, error) {
+	resp, err := f.Head(url)
+	if err != nil {
+		return "", err
+	}
+	return resp.FinalURL, nil
+}
+
+func (f *Fetcher) do(method, url string) (*Response, error) {
+	if f.Cache != nil {
+		rec, err := f.Cache.Get(method, url)
+		if err != nil {
+			return nil, err
+		}
+		ttl := f.ErrorTTL
+		if ttl == 0 {
+			ttl = DefaultErrorTTL
+		}
+		if rec != nil && (f.Offline || !rec.Transient() && !rec.Expired(ttl)) {
+			if rec.Error != "" {
+				return nil, errors.New(rec.Error)
+			}
+			body, err := f.Cache.Body(rec)
+			if err != nil {
+				return nil, err
+			}
+			return &Response{Record: rec, Body: body, Cached: true}, nil
+		}
+	}
+	if f.Offline {
+		return nil, ErrNotCached
+	}
+
+	rec := &Record{Method: method, URL: url, Date: time.Now().UTC()}
+	body, err := f.fetch(rec)
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if f.Cache != nil {
+		if err := f.Cache.Put(rec, body); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Record: rec, Body: body}, nil
+}
+
+func (f *Fetcher) fetch(rec *Record) ([]byte, error) {
+	client := f.Client
+	if client == nil {
+		client = fetch.Default
+	}
+	resp, err := client.Do(rec.Method, rec.URL)
+	if err != nil {
+		return nil, err
+	}
+	rec.Status = resp.Status
+	rec.ContentType = resp.ContentType
+	rec.FinalURL = resp.FinalURL
+	return resp.Body, nil
+}
@@ -0,0 +1,141 @@
+// Package mediacache is a local cache of remote media. The HTTP responses are
+// indexed by the request method and URL, the response bodies are stored by
+// their SHA-256 hashes, so the same content is stored only once.
+//
+// The cache directory layout is:
+//
+//	urls/XX/HASH.json - response records, HASH is a SHA-256 of "METHOD URL"
+//	objects/XX/HASH   - response bodies, HASH is a SHA-256 of body
+//
+// where XX is the first two characters of the HASH.
+package mediacache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/FreeFeed/clio-restore/internal/fetch"
+	"github.com/juju/errors"
+)
+
+// Record is a cached response metadata
+type Record struct {
+	Method      string    `json:"method"`
+	URL         string    `json:"url"`
+	Status      int       `json:"status,omitempty"`      // HTTP status code
+	ContentType string    `json:"contentType,omitempty"` // Content-Type header value
+	FinalURL    string    `json:"finalURL,omitempty"`    // URL after all redirects
+	Hash        string    `json:"hash,omitempty"`        // SHA-256 of body
+	Size        int       `json:"size"`
+	Error       string    `json:"error,omitempty"` // network error, if any
+	Date        time.Time `json:"date"`
+}
+
+// Transient returns true if record holds the network error or the temporary
+// error response that can succeed on retry
+func (r *Record) Transient() bool {
+	return r.Error != "" || fetch.TransientStatus(r.Status)
+}
+
+// Expired returns true if record holds the error response (4xx) older than ttl.
+// Such responses can be fixed by the remote side, so they are not kept forever.
+func (r *Record) Expired(ttl time.Duration) bool {
+	return r.Status >= 400 && time.Since(r.Date) > ttl
+}
+
+// Cache is a media cache in the local directory
+type Cache struct {
+	Dir string
+}
+
+// New creates Cache in the given directory
+func New(dir string) *Cache { return &Cache{Dir: dir} }
+
+func hashOf(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func (c *Cache) recordPath(method, url string) string {
+	h := hashOf([]byte(method + " " + url))
+	return filepath.Join(c.Dir, "urls", h[:2], h+".json")
+}
+
+func (c *Cache) objectPath(hash string) string {
+	return filepath.Join(c.Dir, "objects", hash[:2], hash)
+}
+
+// Get returns the cached record or nil if request is not cached
+func (c *Cache) Get(method, url string) (*Record, error) {
+	data, err := ioutil.ReadFile(c.recordPath(method, url))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rec := new(Record)
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, errors.Annotatef(err, "invalid cache record for %s %s", method, url)
+	}
+	return rec, nil
+}
+
+// Body returns the cached body of the record
+func (c *Cache) Body(rec *Record) ([]byte, error) {
+	if rec.Hash == "" {
+		return nil, nil
+	}
+	body, err := ioutil.ReadFile(c.objectPath(rec.Hash))
+	return body, errors.Annotatef(err, "cannot read cached body of %s", rec.URL)
+}
+
+// Put stores the record and body to the cache. It sets the Hash and Size
+// fields of the record.
+func (c *Cache) Put(rec *Record, body []byte) error {
+	rec.Hash, rec.Size = "", len(body)
+	if body != nil {
+		rec.Hash = hashOf(body)
+		objPath := c.objectPath(rec.Hash)
+		if _, err := os.Stat(objPath); os.IsNotExist(err) {
+			if err := writeFile(objPath, body); err != nil {
+				return err
+			}
+		}
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return writeFile(c.recordPath(rec.Method, rec.URL), data)
+}
+
+// writeFile writes file atomically, so the parallel readers never see
+// the partially written file
+func writeFile(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return errors.Trace(err)
+	}
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Annotatef(err, "cannot write %s", name)
+	}
+	return nil
+}
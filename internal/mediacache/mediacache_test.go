@@ -0,0 +1,96 @@
+package mediacache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FreeFeed/clio-restore/internal/fetch"
+)
+
+func TestFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mediacache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		switch r.URL.Path {
+		case "/a.png", "/b.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		case "/busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/short":
+			http.Redirect(w, r, "/a.png", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cache := New(dir)
+	client := fetch.New(fetch.Options{}) // no retries
+	client.HTTP = srv.Client()
+	f := &Fetcher{Cache: cache, Client: client}
+
+	for _, d := range []struct {
+		URL    string
+		Status int
+		Hits   int
+	}{
+		{srv.URL + "/a.png", 200, 1},
+		{srv.URL + "/a.png", 200, 1},
+		{srv.URL + "/b.png", 200, 2},
+		{srv.URL + "/c.png", 404, 3},
+		{srv.URL + "/c.png", 404, 3},
+		{srv.URL + "/busy", 503, 4}, // temporary errors are refetched
+		{srv.URL + "/busy", 503, 5},
+	} {
+		resp, err := f.Get(d.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != d.Status || hits != d.Hits {
+			t.Errorf("Get %s: got status %d and %d hits, expects %d and %d", d.URL, resp.Status, hits, d.Status, d.Hits)
+		}
+	}
+
+	// Expired error responses are refetched
+	f.ErrorTTL = time.Nanosecond
+	if resp, err := f.Get(srv.URL + "/c.png"); err != nil || resp.Cached || hits != 6 {
+		t.Errorf("Get expired: got %v, %d hits", err, hits)
+	}
+	if resp, err := f.Get(srv.URL + "/a.png"); err != nil || !resp.Cached || hits != 6 {
+		t.Errorf("Get cached: got %v, %d hits", err, hits)
+	}
+
+	if u, err := f.FinalURL(srv.URL + "/short"); err != nil || u != srv.URL+"/a.png" {
+		t.Errorf("FinalURL: got %q, %v", u, err)
+	}
+
+	// The same bodies are stored once
+	objects, _ := filepath.Glob(filepath.Join(dir, "objects", "*", "*"))
+	if len(objects) != 3 { // "png", the 404 page and the empty 503 page
+		t.Errorf("Expects 3 cached objects, got %d", len(objects))
+	}
+
+	off := &Fetcher{Cache: cache, Offline: true}
+	resp, err := off.Get(srv.URL + "/b.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Cached || string(resp.Body) != "png" || resp.ContentType != "image/png" {
+		t.Errorf("Offline Get: unexpected response %+v", resp)
+	}
+	if _, err := off.Get(srv.URL + "/d.png"); err != ErrNotCached {
+		t.Errorf("Offline Get: expects ErrNotCached, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Default is the registry of the program metrics
+var Default = NewRegistry()
+
+// WriteInterval is the interval of the metrics file updates
+const WriteInterval = 15 * time.Second
+
+var (
+	fileFlag string
+	addrFlag string
+	stop     chan struct{}
+	done     chan struct{}
+)
+
+func init() {
+	flag.StringVar(&fileFlag, "metrics-file", "", "write metrics to this file in the Prometheus text format (use .prom extension for node_exporter)")
+	flag.StringVar(&addrFlag, "metrics-addr", "", "serve metrics on http://ADDR/metrics (e.g. localhost:9150)")
+}
+
+// Start starts the export of the Default metrics by the command line flags.
+// The metrics file is updated every WriteInterval.
+func Start() error {
+	if addrFlag != "" {
+		ln, err := net.Listen("tcp", addrFlag)
+		if err != nil {
+			return errors.Annotate(err, "cannot start metrics server")
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", Default)
+		go http.Serve(ln, mux)
+	}
+
+	if fileFlag != "" {
+		if err := Default.WriteFile(fileFlag); err != nil {
+			return err
+		}
+		stop, done = make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(done)
+			ticker := time.NewTicker(WriteInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					Default.WriteFile(fileFlag)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// Stop stops the periodic updates and writes the metrics file the last time
+func Stop() error {
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+	stop = nil
+	return Default.WriteFile(fileFlag)
+}
@@ -0,0 +1,250 @@
+// Package metrics is a minimal registry of counters, gauges and histograms
+// exposed in the Prometheus text format. Metrics can be written to a file
+// for the node_exporter textfile collector or served over HTTP.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// DefBuckets are the default histogram buckets (in seconds)
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry is a set of metrics. Registry is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// NewRegistry returns a new empty Registry
+func NewRegistry() *Registry { return new(Registry) }
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// metric is a family of series with the same name and label names
+type metric struct {
+	reg     *Registry
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+	series  map[string]*series // key is the joined label values
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // counter and gauge value, histogram sum
+	counts      []uint64 // histogram bucket counts (not cumulative)
+	count       uint64   // histogram count
+}
+
+func (r *Registry) add(m *metric) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, old := range r.metrics {
+		if old.name == m.name {
+			panic("metric " + m.name + " is already registered")
+		}
+	}
+	m.reg = r
+	m.series = make(map[string]*series)
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// get returns series with the given label values, r.mu must be locked
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s requires %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if m.typ == histogramType {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Counter is a monotonically increasing value
+type Counter struct{ m *metric }
+
+// Counter registers a new counter with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.add(&metric{name: name, help: help, typ: counterType, labels: labels})}
+}
+
+// Inc increments counter by 1
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Add adds v (that must be non-negative) to counter
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.m.reg.mu.Lock()
+	defer c.m.reg.mu.Unlock()
+	c.m.get(labelValues).value += v
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct{ m *metric }
+
+// Gauge registers a new gauge with the given label names
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.add(&metric{name: name, help: help, typ: gaugeType, labels: labels})}
+}
+
+// Set sets the gauge value
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.reg.mu.Lock()
+	defer g.m.reg.mu.Unlock()
+	g.m.get(labelValues).value = v
+}
+
+// Add adds v to gauge
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.reg.mu.Lock()
+	defer g.m.reg.mu.Unlock()
+	g.m.get(labelValues).value += v
+}
+
+// Histogram counts observations in the buckets
+type Histogram struct{ m *metric }
+
+// Histogram registers a new histogram with the given buckets (upper bounds in
+// increasing order) and label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r.add(&metric{name: name, help: help, typ: histogramType, labels: labels, buckets: buckets})}
+}
+
+// Observe adds observation v to histogram
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.reg.mu.Lock()
+	defer h.m.reg.mu.Unlock()
+	s := h.m.get(labelValues)
+	s.value += v
+	s.count++
+	if i := sort.SearchFloat64s(h.m.buckets, v); i < len(s.counts) {
+		s.counts[i]++
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	buf := new(strings.Builder)
+	for _, m := range r.metrics {
+		m.write(buf)
+	}
+	r.mu.Unlock()
+
+	n, err := io.WriteString(w, buf.String())
+	return int64(n), err
+}
+
+// WriteFile atomically writes metrics to the file. The node_exporter textfile
+// collector requires the .prom extension.
+func (r *Registry) WriteFile(fileName string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".")
+	if err != nil {
+		return errors.Annotate(err, "cannot create metrics file")
+	}
+	if _, err := r.WriteTo(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Annotate(err, "cannot write metrics file")
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Annotate(err, "cannot write metrics file")
+	}
+	os.Chmod(tmp.Name(), 0644)
+	return errors.Annotate(os.Rename(tmp.Name(), fileName), "cannot write metrics file")
+}
+
+// ServeHTTP serves metrics in the Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func (m *metric) write(w *strings.Builder) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escape(m.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := m.series[k]
+		if m.typ != histogramType {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelsString(s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, b := range m.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelsString(s.labelValues, formatFloat(b)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelsString(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, m.labelsString(s.labelValues, ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, m.labelsString(s.labelValues, ""), s.count)
+	}
+}
+
+// labelsString returns the {name="value",...} string, le is added if not empty
+func (m *metric) labelsString(values []string, le string) string {
+	var pairs []string
+	for i, name := range m.labels {
+		pairs = append(pairs, name+`="`+escape(values[i], true)+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(s string, quotes bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quotes {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
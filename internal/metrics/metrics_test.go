@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_fetches_total", "Fetches by host.", "host", "status")
+	g := r.Gauge("test_running", "Running flag.")
+	h := r.Histogram("test_duration_seconds", "Duration\nof \\ tx.", []float64{0.1, 1})
+
+	c.Inc("b.com", "404")
+	c.Add(2, "a.com", "200")
+	c.Inc(`q"u`, "200")
+	g.Set(1)
+	g.Add(-0.5)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(1)
+	h.Observe(3)
+
+	expected := `# HELP test_fetches_total Fetches by host.
+# TYPE test_fetches_total counter
+test_fetches_total{host="a.com",status="200"} 2
+test_fetches_total{host="b.com",status="404"} 1
+test_fetches_total{host="q\"u",status="200"} 1
+# HELP test_running Running flag.
+# TYPE test_running gauge
+test_running 0.5
+# HELP test_duration_seconds Duration\nof \\ tx.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 3
+test_duration_seconds_bucket{le="+Inf"} 4
+test_duration_seconds_sum 4.55
+test_duration_seconds_count 4
+`
+	buf := new(bytes.Buffer)
+	if _, err := r.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Body.String() != expected {
+		t.Errorf("unexpected HTTP output:\n%s", rec.Body.String())
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRegistry()
+	r.Counter("test_total", "Test.").Inc()
+	fileName := filepath.Join(dir, "test.prom")
+	if err := r.WriteFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "# HELP test_total Test.\n# TYPE test_total counter\ntest_total 1\n"; string(data) != expected {
+		t.Errorf("unexpected file content:\n%s", data)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("temporary files are left in %s", dir)
+	}
+}
+
+func TestLabelsMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong number of label values is accepted")
+		}
+	}()
+	NewRegistry().Counter("test_total", "Test.", "a").Inc()
+}
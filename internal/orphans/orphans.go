@@ -0,0 +1,89 @@
+// Package orphans keeps the list of stored attachment files that are not
+// referenced from the DB and could not be deleted immediately. These files
+// should be removed later by the 'clio-rollback -sweep-orphans' call.
+package orphans
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Record is an orphaned file record
+type Record struct {
+	Key     string    // file path in storage
+	Date    time.Time // when the file became orphaned
+	Comment string    // optional comment (i.e. the archive entry name)
+}
+
+var appendMu sync.Mutex
+
+// Append adds records to the end of file. Append is safe for concurrent use.
+func Append(fileName string, recs ...Record) error {
+	appendMu.Lock()
+	defer appendMu.Unlock()
+
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return errors.Annotate(err, "cannot open orphans file")
+	}
+	defer f.Close()
+
+	for _, r := range recs {
+		if _, err := f.WriteString(r.String() + "\n"); err != nil {
+			return errors.Annotate(err, "cannot write to orphans file")
+		}
+	}
+	return nil
+}
+
+// Read reads all records from file. It returns an empty list if file does not exists.
+func Read(fileName string) (recs []Record, err error) {
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot open orphans file")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 3)
+		if parts[0] == "" {
+			continue
+		}
+		r := Record{Key: parts[0]}
+		if len(parts) > 1 {
+			r.Date, _ = time.Parse(time.RFC3339, parts[1])
+		}
+		if len(parts) > 2 {
+			r.Comment = parts[2]
+		}
+		recs = append(recs, r)
+	}
+	return recs, errors.Annotate(scanner.Err(), "cannot read orphans file")
+}
+
+// Write replaces file content by the given records
+func Write(fileName string, recs []Record) error {
+	var lines []string
+	for _, r := range recs {
+		lines = append(lines, r.String()+"\n")
+	}
+	return errors.Annotate(
+		ioutil.WriteFile(fileName, []byte(strings.Join(lines, "")), 0666),
+		"cannot write orphans file",
+	)
+}
+
+func (r Record) String() string {
+	return fmt.Sprintf("%s\t%s\t%s", r.Key, r.Date.Format(time.RFC3339), r.Comment)
+}
@@ -0,0 +1,54 @@
+package orphans
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAppendRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "orphans.txt")
+
+	if recs, err := Read(fileName); err != nil || len(recs) != 0 {
+		t.Fatalf("Read of non-existing file: got %v, %v", recs, err)
+	}
+
+	date := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	recs := []Record{
+		{"attachments/a.jpg", date, "e/0123abcd"},
+		{"attachments/thumbnails/a.jpg", date, ""},
+	}
+	if err := Append(fileName, recs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append(fileName, recs[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, recs) {
+		t.Errorf("Read: got %v, expects %v", read, recs)
+	}
+
+	if err := Write(fileName, recs[1:]); err != nil {
+		t.Fatal(err)
+	}
+	read, err = Read(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, recs[1:]) {
+		t.Errorf("Read after Write: got %v, expects %v", read, recs[1:])
+	}
+}
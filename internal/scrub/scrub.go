@@ -0,0 +1,441 @@
+// Package scrub removes the private metadata (EXIF, XMP, IPTC, text chunks,
+// comments) from JPEG, PNG, GIF and WebP images. Only the metadata blocks are
+// removed, the image data is not re-encoded.
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/juju/errors"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Policy is a metadata policy
+type Policy int
+
+// Metadata policies
+const (
+	// Safe keeps only the orientation and the color profile
+	Safe Policy = iota
+	// Strip removes all metadata but the orientation (image without it
+	// would be shown rotated)
+	Strip
+	// Keep keeps all metadata
+	Keep
+)
+
+// ParsePolicy parses policy name: "safe", "strip" or "keep". Empty name
+// means Safe.
+func ParsePolicy(name string) (Policy, error) {
+	switch name {
+	case "", "safe":
+		return Safe, nil
+	case "strip":
+		return Strip, nil
+	case "keep":
+		return Keep, nil
+	}
+	return Safe, errors.Errorf("unknown metadata policy: %q", name)
+}
+
+// Scrub removes metadata from image according to policy. Format is the
+// image.DecodeConfig format name, images of other than "jpeg", "png", "gif"
+// and "webp" formats are returned as is. Scrub returns the names of the removed
+// metadata items, like "EXIF:GPSLatitude" or "PNG text:Author".
+func Scrub(body []byte, format string, policy Policy) (result []byte, removed []string, err error) {
+	if policy == Keep {
+		return body, nil, nil
+	}
+	switch format {
+	case "jpeg":
+		return scrubJPEG(body, policy)
+	case "png":
+		return scrubPNG(body, policy)
+	case "gif":
+		return scrubGIF(body)
+	case "webp":
+		return scrubWebP(body, policy)
+	}
+	return body, nil, nil
+}
+
+var errTruncated = errors.New("unexpected end of image")
+
+const (
+	exifHeader = "Exif\x00\x00"
+	xmpHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+	iccHeader  = "ICC_PROFILE\x00"
+)
+
+func scrubJPEG(body []byte, policy Policy) ([]byte, []string, error) {
+	if len(body) < 2 || body[0] != 0xFF || body[1] != 0xD8 {
+		return nil, nil, errors.New("not a JPEG image")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(body)))
+	out.Write(body[:2])
+	var removed []string
+
+	pos := 2
+	for {
+		if pos+2 > len(body) {
+			return nil, nil, errTruncated
+		}
+		if body[pos] != 0xFF {
+			return nil, nil, errors.Errorf("invalid JPEG marker at %d", pos)
+		}
+		marker := body[pos+1]
+		if marker == 0xFF { // fill byte
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // start of scan or end of image
+			out.Write(body[pos:])
+			break
+		}
+		if pos+4 > len(body) {
+			return nil, nil, errTruncated
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(body[pos+2:]))
+		if end < pos+4 || end > len(body) {
+			return nil, nil, errTruncated
+		}
+		data := body[pos+4 : end]
+
+		keep, replacement, names := jpegSegment(marker, data, policy)
+		if keep {
+			out.Write(body[pos:end])
+		} else {
+			out.Write(replacement)
+			removed = append(removed, names...)
+		}
+		pos = end
+	}
+	return out.Bytes(), removed, nil
+}
+
+// jpegSegment decides what to do with the JPEG segment. If segment is not
+// kept, it is replaced by the (optional) replacement.
+func jpegSegment(marker byte, data []byte, policy Policy) (keep bool, replacement []byte, removed []string) {
+	switch {
+	case marker == 0xE0: // JFIF
+		if bytes.HasPrefix(data, []byte("JFIF\x00")) || bytes.HasPrefix(data, []byte("JFXX\x00")) {
+			return true, nil, nil
+		}
+	case marker == 0xE1 && bytes.HasPrefix(data, []byte(exifHeader)):
+		names, orient := exifFields(data)
+		if orient > 1 {
+			replacement = orientationSegment(orient)
+			names = withoutOrientation(names)
+		}
+		return false, replacement, names
+	case marker == 0xE1 && bytes.HasPrefix(data, []byte(xmpHeader)):
+		return false, nil, []string{"XMP"}
+	case marker == 0xE2 && bytes.HasPrefix(data, []byte(iccHeader)):
+		if policy == Safe {
+			return true, nil, nil
+		}
+		return false, nil, []string{"ICC profile"}
+	case marker == 0xED:
+		return false, nil, []string{"IPTC"}
+	case marker == 0xEE: // Adobe, required for the color transform
+		return true, nil, nil
+	case marker == 0xFE:
+		return false, nil, []string{"comment"}
+	case marker < 0xE0 || marker > 0xEF: // not an APPn
+		return true, nil, nil
+	}
+	return false, nil, []string{fmt.Sprintf("APP%d", marker-0xE0)}
+}
+
+type walkFunc func(name exif.FieldName, tag *tiff.Tag) error
+
+func (f walkFunc) Walk(name exif.FieldName, tag *tiff.Tag) error { return f(name, tag) }
+
+// exifFields returns the sorted names of the EXIF fields and the orientation
+// value
+func exifFields(data []byte) (names []string, orient int) {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return []string{"EXIF"}, 0
+	}
+	x.Walk(walkFunc(func(name exif.FieldName, tag *tiff.Tag) error {
+		names = append(names, "EXIF:"+string(name))
+		if name == exif.Orientation {
+			orient, _ = tag.Int(0)
+		}
+		return nil
+	}))
+	if len(names) == 0 {
+		names = []string{"EXIF"}
+	}
+	sort.Strings(names)
+	return
+}
+
+func withoutOrientation(names []string) []string {
+	for i, n := range names {
+		if n == "EXIF:"+string(exif.Orientation) {
+			return append(names[:i], names[i+1:]...)
+		}
+	}
+	return names
+}
+
+// orientationSegment returns the APP1 segment with the only Orientation tag
+func orientationSegment(orient int) []byte {
+	b := new(bytes.Buffer)
+	b.Write([]byte{0xFF, 0xE1, 0, 2 + 6 + 8 + 2 + 12 + 4})
+	b.WriteString(exifHeader)
+	b.Write(orientationTIFF(orient))
+	return b.Bytes()
+}
+
+// orientationTIFF returns the EXIF (TIFF) data with the only Orientation tag
+func orientationTIFF(orient int) []byte {
+	b := new(bytes.Buffer)
+	b.WriteString("MM\x00\x2A")                       // big-endian TIFF
+	binary.Write(b, binary.BigEndian, uint32(8))      // IFD0 offset
+	binary.Write(b, binary.BigEndian, uint16(1))      // number of entries
+	binary.Write(b, binary.BigEndian, uint16(0x0112)) // Orientation
+	binary.Write(b, binary.BigEndian, uint16(3))      // SHORT
+	binary.Write(b, binary.BigEndian, uint32(1))      // count
+	binary.Write(b, binary.BigEndian, uint16(orient)) // value
+	binary.Write(b, binary.BigEndian, uint16(0))      // padding
+	binary.Write(b, binary.BigEndian, uint32(0))      // next IFD
+	return b.Bytes()
+}
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+func scrubPNG(body []byte, policy Policy) ([]byte, []string, error) {
+	if !bytes.HasPrefix(body, []byte(pngSignature)) {
+		return nil, nil, errors.New("not a PNG image")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(body)))
+	out.WriteString(pngSignature)
+	var removed []string
+
+	pos := len(pngSignature)
+	for pos < len(body) {
+		if pos+8 > len(body) {
+			return nil, nil, errTruncated
+		}
+		length := int(binary.BigEndian.Uint32(body[pos:]))
+		typ := string(body[pos+4 : pos+8])
+		end := pos + 12 + length
+		if length < 0 || end > len(body) {
+			return nil, nil, errTruncated
+		}
+		data := body[pos+8 : pos+8+length]
+
+		name := ""
+		switch typ {
+		case "tEXt", "zTXt", "iTXt":
+			keyword := data
+			if i := bytes.IndexByte(data, 0); i >= 0 {
+				keyword = data[:i]
+			}
+			name = "PNG text:" + string(keyword)
+		case "eXIf":
+			name = "EXIF"
+		case "tIME":
+			name = "PNG time"
+		case "iCCP", "sRGB", "gAMA", "cHRM":
+			if policy != Safe {
+				name = "PNG " + typ
+			}
+		}
+		if name != "" {
+			removed = append(removed, name)
+		} else {
+			out.Write(body[pos:end])
+		}
+		pos = end
+		if typ == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), removed, nil
+}
+
+func scrubGIF(body []byte) ([]byte, []string, error) {
+	if len(body) < 13 || !(bytes.HasPrefix(body, []byte("GIF87a")) || bytes.HasPrefix(body, []byte("GIF89a"))) {
+		return nil, nil, errors.New("not a GIF image")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(body)))
+	var removed []string
+
+	pos := 13 // header and logical screen descriptor
+	if body[10]&0x80 != 0 {
+		pos += 3 << (body[10]&0x07 + 1) // global color table
+	}
+	if pos > len(body) {
+		return nil, nil, errTruncated
+	}
+	out.Write(body[:pos])
+
+	// skipSubBlocks returns position after the data sub-blocks at p. If the
+	// data is truncated, it returns position after the last complete
+	// sub-block and errTruncated.
+	skipSubBlocks := func(p int) (int, error) {
+		for {
+			if p >= len(body) || p+1+int(body[p]) > len(body) {
+				return p, errTruncated
+			}
+			n := int(body[p])
+			p += 1 + n
+			if n == 0 {
+				return p, nil
+			}
+		}
+	}
+
+	// Truncated GIFs are common in the old archives and are still readable,
+	// so the missing trailer is added and the incomplete blocks are dropped
+	// or (image data) terminated.
+	truncated := func() ([]byte, []string, error) {
+		out.WriteByte(0x3B)
+		return out.Bytes(), removed, nil
+	}
+
+	for {
+		if pos >= len(body) {
+			return truncated()
+		}
+		start := pos
+		switch body[pos] {
+		case 0x3B: // trailer
+			out.Write(body[pos:])
+			return out.Bytes(), removed, nil
+		case 0x2C: // image descriptor
+			if pos+10 > len(body) {
+				return truncated()
+			}
+			flags := body[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1) // local color table
+			}
+			pos++ // LZW minimum code size
+			if pos > len(body) {
+				return truncated()
+			}
+			end, err := skipSubBlocks(pos)
+			if err != nil {
+				out.Write(body[start:end])
+				out.WriteByte(0) // block terminator
+				return truncated()
+			}
+			out.Write(body[start:end])
+			pos = end
+		case 0x21: // extension
+			if pos+2 > len(body) {
+				return truncated()
+			}
+			label := body[pos+1]
+			end, err := skipSubBlocks(pos + 2)
+			if err != nil {
+				return truncated()
+			}
+			name := ""
+			switch {
+			case label == 0xFE:
+				name = "GIF comment"
+			case label == 0xFF && end >= pos+14 && body[pos+2] == 11:
+				if app := string(body[pos+3 : pos+14]); app != "NETSCAPE2.0" && app != "ANIMEXTS1.0" {
+					name = "GIF application:" + app
+				}
+			}
+			if name != "" {
+				removed = append(removed, name)
+			} else {
+				out.Write(body[start:end])
+			}
+			pos = end
+		default:
+			return nil, nil, errors.Errorf("invalid GIF block at %d", pos)
+		}
+	}
+}
+
+// VP8X flags of the metadata chunks
+const (
+	webpICCFlag  = 0x20
+	webpEXIFFlag = 0x08
+	webpXMPFlag  = 0x04
+)
+
+func scrubWebP(body []byte, policy Policy) ([]byte, []string, error) {
+	if len(body) < 12 || string(body[:4]) != "RIFF" || string(body[8:12]) != "WEBP" {
+		return nil, nil, errors.New("not a WebP image")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(body)))
+	out.Write(body[:12])
+	var (
+		removed []string
+		vp8x    = -1 // position of the VP8X flags in out
+		flags   byte // flags of the removed chunks
+	)
+
+	pos := 12
+	for pos < len(body) {
+		if pos+8 > len(body) {
+			return nil, nil, errTruncated
+		}
+		typ := string(body[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(body[pos+4:]))
+		end := pos + 8 + size + size%2 // chunks are padded to even size
+		if size < 0 || pos+8+size > len(body) {
+			return nil, nil, errTruncated
+		}
+		if end > len(body) {
+			end = len(body)
+		}
+		data := body[pos+8 : pos+8+size]
+
+		switch typ {
+		case "EXIF":
+			if !bytes.HasPrefix(data, []byte(exifHeader)) {
+				data = append([]byte(exifHeader), data...)
+			}
+			names, orient := exifFields(data)
+			if orient > 1 {
+				tiff := orientationTIFF(orient)
+				out.WriteString("EXIF")
+				binary.Write(out, binary.LittleEndian, uint32(len(tiff)))
+				out.Write(tiff) // even size, no padding
+				names = withoutOrientation(names)
+			} else {
+				flags |= webpEXIFFlag
+			}
+			removed = append(removed, names...)
+		case "XMP ":
+			flags |= webpXMPFlag
+			removed = append(removed, "XMP")
+		case "ICCP":
+			if policy == Safe {
+				out.Write(body[pos:end])
+			} else {
+				flags |= webpICCFlag
+				removed = append(removed, "ICC profile")
+			}
+		case "VP8X":
+			vp8x = out.Len() + 8
+			out.Write(body[pos:end])
+		default:
+			out.Write(body[pos:end])
+		}
+		pos = end
+	}
+
+	res := out.Bytes()
+	if vp8x >= 0 && vp8x < len(res) {
+		res[vp8x] &^= flags
+	}
+	binary.LittleEndian.PutUint32(res[4:], uint32(len(res)-8))
+	return res, removed, nil
+}
@@ -0,0 +1,274 @@
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"golang.org/x/image/webp"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	return img
+}
+
+func jpegSeg(marker byte, data string) []byte {
+	b := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(data)+2))
+	return append(b, data...)
+}
+
+// testJPEG returns JPEG image with the given segments inserted after SOI
+func testJPEG(t *testing.T, segments ...[]byte) []byte {
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	body := buf.Bytes()
+	res := append([]byte{}, body[:2]...)
+	for _, s := range segments {
+		res = append(res, s...)
+	}
+	return append(res, body[2:]...)
+}
+
+func pngChunk(typ, data string) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE([]byte(typ+data)))
+	return append(b, crc...)
+}
+
+// testPNG returns PNG image with the given chunks inserted after IHDR
+func testPNG(t *testing.T, chunks ...[]byte) []byte {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	body := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 12 + 13
+	res := append([]byte{}, body[:ihdrEnd]...)
+	for _, c := range chunks {
+		res = append(res, c...)
+	}
+	return append(res, body[ihdrEnd:]...)
+}
+
+func TestScrubJPEG(t *testing.T) {
+	body := testJPEG(t,
+		orientationSegment(6),
+		jpegSeg(0xE1, xmpHeader+"<x:xmpmeta/>"),
+		jpegSeg(0xE2, iccHeader+"\x01\x01profile"),
+		jpegSeg(0xFE, "Camera serial 12345"),
+	)
+
+	for _, d := range []struct {
+		Policy  Policy
+		Removed []string
+		Orient  int
+		ICC     bool
+	}{
+		{Keep, nil, 6, true},
+		{Safe, []string{"XMP", "comment"}, 6, true},
+		{Strip, []string{"XMP", "ICC profile", "comment"}, 6, false},
+	} {
+		res, removed, err := Scrub(body, "jpeg", d.Policy)
+		if err != nil {
+			t.Fatalf("Policy %d: %v", d.Policy, err)
+		}
+		if !reflect.DeepEqual(removed, d.Removed) {
+			t.Errorf("Policy %d: got removed %q, expects %q", d.Policy, removed, d.Removed)
+		}
+		orient := 0
+		if x, err := exif.Decode(bytes.NewReader(res)); err == nil {
+			if tag, err := x.Get(exif.Orientation); err == nil {
+				orient, _ = tag.Int(0)
+			}
+		}
+		if orient != d.Orient {
+			t.Errorf("Policy %d: got orientation %d, expects %d", d.Policy, orient, d.Orient)
+		}
+		if icc := bytes.Contains(res, []byte(iccHeader)); icc != d.ICC {
+			t.Errorf("Policy %d: got ICC profile %v, expects %v", d.Policy, icc, d.ICC)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(res)); err != nil {
+			t.Errorf("Policy %d: cannot decode result: %v", d.Policy, err)
+		}
+	}
+}
+
+func TestScrubPNG(t *testing.T) {
+	body := testPNG(t,
+		pngChunk("gAMA", "\x00\x00\xb1\x8f"),
+		pngChunk("tEXt", "Author\x00John"),
+		pngChunk("tIME", "\x07\xd8\x01\x01\x00\x00\x00"),
+	)
+
+	for _, d := range []struct {
+		Policy  Policy
+		Removed []string
+	}{
+		{Safe, []string{"PNG text:Author", "PNG time"}},
+		{Strip, []string{"PNG gAMA", "PNG text:Author", "PNG time"}},
+	} {
+		res, removed, err := Scrub(body, "png", d.Policy)
+		if err != nil {
+			t.Fatalf("Policy %d: %v", d.Policy, err)
+		}
+		if !reflect.DeepEqual(removed, d.Removed) {
+			t.Errorf("Policy %d: got removed %q, expects %q", d.Policy, removed, d.Removed)
+		}
+		if bytes.Contains(res, []byte("John")) {
+			t.Errorf("Policy %d: text is not removed", d.Policy)
+		}
+		if _, err := png.Decode(bytes.NewReader(res)); err != nil {
+			t.Errorf("Policy %d: cannot decode result: %v", d.Policy, err)
+		}
+	}
+}
+
+func TestScrubGIF(t *testing.T) {
+	g := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+	body := buf.Bytes()
+	// Insert comment before the trailer
+	comment := []byte{0x21, 0xFE, 5, 'h', 'e', 'l', 'l', 'o', 0}
+	body = append(append(append([]byte{}, body[:len(body)-1]...), comment...), 0x3B)
+
+	res, removed, err := Scrub(body, "gif", Strip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"GIF comment"}) {
+		t.Errorf("Got removed %q", removed)
+	}
+	res2, err := gif.DecodeAll(bytes.NewReader(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res2.Image) != 2 || res2.LoopCount != 0 {
+		t.Errorf("Got %d frames, loop count %d", len(res2.Image), res2.LoopCount)
+	}
+}
+
+func TestScrubTruncatedGIF(t *testing.T) {
+	g := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+	full := buf.Bytes()
+
+	for _, d := range []struct {
+		Name   string
+		Body   []byte
+		Frames int
+	}{
+		{"no trailer", full[:len(full)-1], 2},
+		{"truncated frame", full[:len(full)-4], 1},
+	} {
+		res, _, err := Scrub(d.Body, "gif", Strip)
+		if err != nil {
+			t.Errorf("%s: %v", d.Name, err)
+			continue
+		}
+		if res[len(res)-1] != 0x3B {
+			t.Errorf("%s: no trailer", d.Name)
+		}
+		res2, err := gif.DecodeAll(bytes.NewReader(res))
+		if d.Frames == 2 && err != nil {
+			t.Errorf("%s: cannot decode: %v", d.Name, err)
+		} else if err == nil && len(res2.Image) < d.Frames {
+			t.Errorf("%s: got %d frames, expects %d", d.Name, len(res2.Image), d.Frames)
+		}
+	}
+}
+
+func webpChunk(typ, data string) []byte {
+	b := append([]byte(typ), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(data)))
+	b = append(b, data...)
+	if len(data)%2 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func TestScrubWebP(t *testing.T) {
+	// 1x1 lossless image
+	vp8l := "\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00"
+	var chunks []byte
+	for _, c := range [][]byte{
+		webpChunk("VP8X", "\x2c\x00\x00\x00\x00\x00\x00\x00\x00\x00"), // ICC, EXIF and XMP flags
+		webpChunk("ICCP", "prof!"),
+		webpChunk("VP8L", vp8l),
+		webpChunk("EXIF", string(orientationTIFF(6))),
+		webpChunk("XMP ", "<x:xmpmeta/>"),
+	} {
+		chunks = append(chunks, c...)
+	}
+	body := []byte("RIFF\x00\x00\x00\x00WEBP")
+	binary.LittleEndian.PutUint32(body[4:], uint32(len(chunks)+4))
+	body = append(body, chunks...)
+
+	for _, d := range []struct {
+		Policy  Policy
+		Removed []string
+		Flags   byte
+		ICC     bool
+	}{
+		{Safe, []string{"XMP"}, 0x28, true},
+		{Strip, []string{"ICC profile", "XMP"}, 0x08, false},
+	} {
+		res, removed, err := Scrub(body, "webp", d.Policy)
+		if err != nil {
+			t.Fatalf("Policy %d: %v", d.Policy, err)
+		}
+		if !reflect.DeepEqual(removed, d.Removed) {
+			t.Errorf("Policy %d: got removed %q, expects %q", d.Policy, removed, d.Removed)
+		}
+		if flags := res[20]; flags != d.Flags {
+			t.Errorf("Policy %d: got VP8X flags %#x, expects %#x", d.Policy, flags, d.Flags)
+		}
+		if icc := bytes.Contains(res, []byte("prof!")); icc != d.ICC {
+			t.Errorf("Policy %d: got ICC profile %v, expects %v", d.Policy, icc, d.ICC)
+		}
+		if bytes.Contains(res, []byte("xmpmeta")) || !bytes.Contains(res, orientationTIFF(6)) {
+			t.Errorf("Policy %d: XMP is not removed or orientation is lost", d.Policy)
+		}
+		if size := binary.LittleEndian.Uint32(res[4:]); int(size) != len(res)-8 {
+			t.Errorf("Policy %d: got RIFF size %d, expects %d", d.Policy, size, len(res)-8)
+		}
+		if _, err := webp.Decode(bytes.NewReader(res)); err != nil {
+			t.Errorf("Policy %d: cannot decode result: %v", d.Policy, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Local is a storage in the local directory
+type Local struct {
+	Dir string
+}
+
+// NewLocal creates a new local storage in dir
+func NewLocal(dir string) *Local { return &Local{Dir: dir} }
+
+func (s *Local) fileName(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(key))
+}
+
+// Put implements Storage
+func (s *Local) Put(key string, body []byte, contentType, name string) error {
+	fileName := s.fileName(key)
+	if err := os.MkdirAll(filepath.Dir(fileName), 0777); err != nil {
+		return errors.Annotate(err, "cannot create directory")
+	}
+	return errors.Annotate(ioutil.WriteFile(fileName, body, 0666), "cannot write file")
+}
+
+// Copy implements Storage. Copy is a hard link to the source file if the file
+// system supports it.
+func (s *Local) Copy(srcKey, dstKey string, contentType, name string) error {
+	dstName := s.fileName(dstKey)
+	if err := os.MkdirAll(filepath.Dir(dstName), 0777); err != nil {
+		return errors.Annotate(err, "cannot create directory")
+	}
+	if err := os.Link(s.fileName(srcKey), dstName); err == nil {
+		return nil
+	}
+	body, err := s.Read(srcKey)
+	if err != nil {
+		return err
+	}
+	return s.Put(dstKey, body, contentType, name)
+}
+
+// Read implements Storage
+func (s *Local) Read(key string) ([]byte, error) {
+	body, err := ioutil.ReadFile(s.fileName(key))
+	return body, errors.Annotatef(err, "cannot read %s", key)
+}
+
+// Delete implements Storage
+func (s *Local) Delete(keys ...string) error {
+	for _, key := range keys {
+		if err := os.Remove(s.fileName(key)); err != nil && !os.IsNotExist(err) {
+			return errors.Annotatef(err, "cannot delete %s", key)
+		}
+	}
+	return nil
+}
+
+// Exists implements Storage
+func (s *Local) Exists(key string) (bool, error) {
+	_, err := os.Stat(s.fileName(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List implements Storage
+func (s *Local) List(prefix string, foo func(key string) error) error {
+	// Walk the deepest directory that contains prefix
+	root := s.Dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = s.fileName(prefix[:i])
+	}
+	err := filepath.Walk(root, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && fileName == root {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, fileName)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			return foo(key)
+		}
+		return nil
+	})
+	return err
+}
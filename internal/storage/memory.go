@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// Memory is an in-memory storage, useful for tests
+type Memory struct {
+	mu      sync.Mutex
+	objects map[string]*Object
+}
+
+// Object is a file stored in Memory
+type Object struct {
+	Body        []byte
+	ContentType string
+	Name        string
+}
+
+// NewMemory creates a new empty in-memory storage
+func NewMemory() *Memory { return &Memory{objects: make(map[string]*Object)} }
+
+// Get returns stored object or nil if object not found
+func (s *Memory) Get(key string) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[key]
+}
+
+// Put implements Storage
+func (s *Memory) Put(key string, body []byte, contentType, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &Object{
+		Body:        append([]byte(nil), body...),
+		ContentType: contentType,
+		Name:        name,
+	}
+	return nil
+}
+
+// Copy implements Storage
+func (s *Memory) Copy(srcKey, dstKey string, contentType, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.objects[srcKey]
+	if !ok {
+		return errors.Errorf("cannot copy %s: file not found", srcKey)
+	}
+	// Bodies are never modified, so they can be shared
+	s.objects[dstKey] = &Object{Body: src.Body, ContentType: contentType, Name: name}
+	return nil
+}
+
+// Read implements Storage
+func (s *Memory) Read(key string) ([]byte, error) {
+	obj := s.Get(key)
+	if obj == nil {
+		return nil, errors.Errorf("cannot read %s: file not found", key)
+	}
+	return obj.Body, nil
+}
+
+// Delete implements Storage
+func (s *Memory) Delete(keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		delete(s.objects, key)
+	}
+	return nil
+}
+
+// Exists implements Storage
+func (s *Memory) Exists(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[key]
+	return ok, nil
+}
+
+// List implements Storage
+func (s *Memory) List(prefix string, foo func(key string) error) error {
+	s.mu.Lock()
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := foo(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/juju/errors"
+)
+
+// S3 is a storage in the Amazon S3 (or S3-compatible) bucket.
+// All stored files are public-readable.
+type S3 struct {
+	Client *s3.S3
+	Bucket string
+}
+
+// NewS3 creates a new Amazon S3 storage. AWS credentials and region
+// are taken from the environment.
+func NewS3(bucket string) (*S3, error) {
+	awsSession, err := session.NewSession()
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot create AWS session")
+	}
+	return &S3{Client: s3.New(awsSession), Bucket: bucket}, nil
+}
+
+// NewS3Compatible creates a new storage on S3-compatible service with the
+// custom endpoint (like "http://localhost:9000" for MinIO). The pathStyle
+// flag turns on the path-style bucket addressing ("endpoint/bucket/key").
+func NewS3Compatible(bucket, endpoint, region string, pathStyle bool) (*S3, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+	awsSession, err := session.NewSession(
+		aws.NewConfig().
+			WithEndpoint(endpoint).
+			WithRegion(region).
+			WithS3ForcePathStyle(pathStyle),
+	)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot create AWS session")
+	}
+	return &S3{Client: s3.New(awsSession), Bucket: bucket}, nil
+}
+
+// Put implements Storage
+func (s *S3) Put(key string, body []byte, contentType, name string) error {
+	_, err := s.Client.PutObject(
+		new(s3.PutObjectInput).
+			SetBody(bytes.NewReader(body)).
+			SetBucket(s.Bucket).
+			SetKey(key).
+			SetContentType(contentType).
+			SetContentLength(int64(len(body))).
+			SetACL(s3.ObjectCannedACLPublicRead).
+			SetContentDisposition(contentDispositionString("inline", name)),
+	)
+	return errors.Annotatef(err, "cannot upload %s", key)
+}
+
+// Copy implements Storage. Copy is made on the S3 side.
+func (s *S3) Copy(srcKey, dstKey string, contentType, name string) error {
+	_, err := s.Client.CopyObject(
+		new(s3.CopyObjectInput).
+			SetBucket(s.Bucket).
+			SetKey(dstKey).
+			SetCopySource(s.Bucket + "/" + url.PathEscape(srcKey)).
+			SetContentType(contentType).
+			SetMetadataDirective(s3.MetadataDirectiveReplace).
+			SetACL(s3.ObjectCannedACLPublicRead).
+			SetContentDisposition(contentDispositionString("inline", name)),
+	)
+	return errors.Annotatef(err, "cannot copy %s to %s", srcKey, dstKey)
+}
+
+// Read implements Storage
+func (s *S3) Read(key string) ([]byte, error) {
+	out, err := s.Client.GetObject(
+		new(s3.GetObjectInput).
+			SetBucket(s.Bucket).
+			SetKey(key),
+	)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read %s", key)
+	}
+	defer out.Body.Close()
+	body, err := ioutil.ReadAll(out.Body)
+	return body, errors.Annotatef(err, "cannot read %s", key)
+}
+
+// S3 allows to delete up to 1000 objects per request
+const s3DeleteBatchSize = 1000
+
+// Delete implements Storage
+func (s *S3) Delete(keys ...string) error {
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > s3DeleteBatchSize {
+			batch = batch[:s3DeleteBatchSize]
+		}
+		keys = keys[len(batch):]
+
+		del := new(s3.Delete)
+		for _, key := range batch {
+			del.Objects = append(del.Objects, new(s3.ObjectIdentifier).SetKey(key))
+		}
+		out, err := s.Client.DeleteObjects(
+			new(s3.DeleteObjectsInput).
+				SetBucket(s.Bucket).
+				SetDelete(del),
+		)
+		if err != nil {
+			return errors.Annotate(err, "cannot delete objects")
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return errors.Errorf(
+				"cannot delete %s: %s (and %d more errors)",
+				aws.StringValue(e.Key), aws.StringValue(e.Message), len(out.Errors)-1,
+			)
+		}
+	}
+	return nil
+}
+
+// Exists implements Storage
+func (s *S3) Exists(key string) (bool, error) {
+	_, err := s.Client.HeadObject(
+		new(s3.HeadObjectInput).
+			SetBucket(s.Bucket).
+			SetKey(key),
+	)
+	if err, ok := err.(awserr.RequestFailure); ok && err.StatusCode() == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, errors.Annotatef(err, "cannot check %s", key)
+}
+
+// List implements Storage
+func (s *S3) List(prefix string, foo func(key string) error) error {
+	var fooErr error
+	err := s.Client.ListObjectsV2Pages(
+		new(s3.ListObjectsV2Input).
+			SetBucket(s.Bucket).
+			SetPrefix(prefix),
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				if fooErr = foo(aws.StringValue(obj.Key)); fooErr != nil {
+					return false
+				}
+			}
+			return true
+		},
+	)
+	if fooErr != nil {
+		return fooErr
+	}
+	return errors.Annotate(err, "cannot list objects")
+}
+
+var nonASCIIRe = regexp.MustCompile(`[^\x20-\x7f]`)
+
+// Get cross-browser Content-Disposition header for attachment
+func contentDispositionString(disposition, name string) string {
+	if name == "" {
+		return disposition
+	}
+	// Old browsers (IE8) need ASCII-only fallback filenames
+	fileNameASCII := nonASCIIRe.ReplaceAllString(name, "_")
+	// Modern browsers support UTF-8 filenames
+	fileNameUTF8 := url.QueryEscape(name)
+	// Go's QueryEscape replace spaces to '+', not '%20'
+	fileNameUTF8 = strings.Replace(fileNameUTF8, "+", "%20", -1)
+	// Inline version of 'attfnboth' method (http://greenbytes.de/tech/tc2231/#attfnboth)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=utf-8''%s`, disposition, fileNameASCII, fileNameUTF8)
+}
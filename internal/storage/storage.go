@@ -0,0 +1,66 @@
+// Package storage provides the attachments storage backends: local disk,
+// Amazon S3, S3-compatible services (like MinIO) and in-memory storage.
+package storage
+
+import (
+	"github.com/FreeFeed/clio-restore/internal/config"
+	"github.com/juju/errors"
+)
+
+// Storage is an attachments files storage. Keys are the slash-separated
+// paths like "attachments/thumbnails/UID.jpg".
+type Storage interface {
+	// Put stores file under the given key. Name is the original file
+	// name, it may be empty.
+	Put(key string, body []byte, contentType, name string) error
+	// Copy stores a copy of the srcKey file under the dstKey. Storage
+	// should make copy without the file transfer if possible.
+	Copy(srcKey, dstKey string, contentType, name string) error
+	// Read returns the file content.
+	Read(key string) ([]byte, error)
+	// Delete deletes files. Deletion of non-existing file is not an error.
+	Delete(keys ...string) error
+	// Exists returns true if file exists.
+	Exists(key string) (bool, error)
+	// List calls foo for each key that starts with the given prefix.
+	List(prefix string, foo func(key string) error) error
+}
+
+// Storage types
+const (
+	TypeLocal  = "local"
+	TypeS3     = "s3"
+	TypeMemory = "memory"
+)
+
+// New creates storage according to config. If the Storage parameter is not
+// set, the local storage is used when AttDir is defined and S3 otherwise.
+func New(conf *config.Config) (Storage, error) {
+	sType := conf.Storage
+	if sType == "" {
+		if conf.AttDir != "" {
+			sType = TypeLocal
+		} else {
+			sType = TypeS3
+		}
+	}
+
+	switch sType {
+	case TypeLocal:
+		if conf.AttDir == "" {
+			return nil, errors.New("AttDir is required for the local storage")
+		}
+		return NewLocal(conf.AttDir), nil
+	case TypeS3:
+		if conf.S3Bucket == "" {
+			return nil, errors.New("S3Bucket is required for the S3 storage")
+		}
+		if conf.S3Endpoint != "" {
+			return NewS3Compatible(conf.S3Bucket, conf.S3Endpoint, conf.S3Region, conf.S3PathStyle)
+		}
+		return NewS3(conf.S3Bucket)
+	case TypeMemory:
+		return NewMemory(), nil
+	}
+	return nil, errors.Errorf("unknown storage type: %q", sType)
+}
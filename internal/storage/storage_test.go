@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testStorage(t, NewLocal(dir))
+}
+
+func TestMemory(t *testing.T) {
+	s := NewMemory()
+	testStorage(t, s)
+
+	s.Put("attachments/b.png", []byte("png"), "image/png", "b.png")
+	if obj := s.Get("attachments/b.png"); obj == nil || obj.ContentType != "image/png" || obj.Name != "b.png" {
+		t.Errorf("Get: unexpected object %v", obj)
+	}
+}
+
+func testStorage(t *testing.T, s Storage) {
+	keys := []string{
+		"attachments/a.jpg",
+		"attachments/thumbnails/a.jpg",
+		"attachments/thumbnails2/a.jpg",
+		"other/a.jpg",
+	}
+	for _, key := range keys {
+		if err := s.Put(key, []byte(key), "image/jpeg", "a.jpg"); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+
+	if err := s.Copy("other/a.jpg", "other/b.jpg", "image/jpeg", "b.jpg"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if body, err := s.Read("other/b.jpg"); err != nil || string(body) != "other/a.jpg" {
+		t.Errorf("Read of copied file: got %q, %v", body, err)
+	}
+	if err := s.Delete("other/b.jpg"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if _, err := s.Read("other/b.jpg"); err == nil {
+		t.Error("Read of deleted file: expects error")
+	}
+
+	if ok, err := s.Exists("attachments/a.jpg"); !ok || err != nil {
+		t.Errorf("Exists of existing key: got %v, %v", ok, err)
+	}
+	if ok, err := s.Exists("attachments/b.jpg"); ok || err != nil {
+		t.Errorf("Exists of missing key: got %v, %v", ok, err)
+	}
+
+	listTests := []struct {
+		Prefix string
+		Keys   []string
+	}{
+		{"attachments/", keys[:3]},
+		{"attachments/thumb", keys[1:3]},
+		{"attachments/thumbnails/", keys[1:2]},
+		{"none/", nil},
+	}
+	for _, lt := range listTests {
+		var listed []string
+		if err := s.List(lt.Prefix, func(key string) error {
+			listed = append(listed, key)
+			return nil
+		}); err != nil {
+			t.Fatalf("List %q: %v", lt.Prefix, err)
+		}
+		if !reflect.DeepEqual(listed, lt.Keys) {
+			t.Errorf("List %q: got %v, expects %v", lt.Prefix, listed, lt.Keys)
+		}
+	}
+
+	if err := s.Delete("attachments/a.jpg", "attachments/missing.jpg"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if ok, _ := s.Exists("attachments/a.jpg"); ok {
+		t.Error("Deleted key still exists")
+	}
+}
@@ -0,0 +1,31 @@
+package thumbs
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strings"
+)
+
+// FlickrOEmbedURL returns URL of the Flickr oEmbed page of the photo page
+func FlickrOEmbedURL(pageURL string) string {
+	return "https://www.flickr.com/services/oembed?url=" + url.QueryEscape(pageURL)
+}
+
+// ParseFlickrOEmbed returns image URLs from the Flickr oEmbed page
+func ParseFlickrOEmbed(body []byte) ([]string, error) {
+	o := &struct {
+		URL string `xml:"url"`
+	}{}
+	if err := xml.Unmarshal(body, o); err != nil {
+		return nil, err
+	}
+
+	imageURL := o.URL
+	const zTail = "_z.jpg?zz=1"
+	if strings.HasSuffix(imageURL, zTail) {
+		base := imageURL[:len(imageURL)-len(zTail)]
+		return []string{base + ".jpg"}, nil
+	}
+
+	return []string{imageURL}, nil
+}
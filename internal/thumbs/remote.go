@@ -0,0 +1,43 @@
+package thumbs
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// SupportedContentTypes are the accepted content types of the remote images
+var SupportedContentTypes = map[string]bool{
+	"image/jpeg":     true,
+	"image/jpg":      true,
+	"image/png":      true,
+	"image/gif":      true,
+	"image/webp":     true,
+	"image/bmp":      true,
+	"image/x-ms-bmp": true,
+	"image/tiff":     true,
+}
+
+// CheckImageResponse returns error if the fetched response of the remote image
+// URL is not an image. Image content itself is not checked.
+func CheckImageResponse(imageURL string, status int, contentType, finalURL string) error {
+	if status != http.StatusOK { // redirects?
+		return errors.Errorf("error fetching URL: %d %s", status, http.StatusText(status))
+	}
+	if FlickrImageRe.MatchString(imageURL) && isHost(finalURL, "s.yimg.com") {
+		return errors.New("error fetching URL: flickr image not found")
+	}
+	ct := strings.Split(strings.ToLower(contentType), ";")[0]
+	if !SupportedContentTypes[ct] {
+		return errors.Errorf("unsupported content type: %s", contentType)
+	}
+	return nil
+}
+
+// isHost returns true if URL has the given hostname
+func isHost(URL, host string) bool {
+	u, err := url.Parse(URL)
+	return err == nil && u.Hostname() == host
+}
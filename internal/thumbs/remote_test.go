@@ -0,0 +1,26 @@
+package thumbs
+
+import "testing"
+
+func TestCheckImageResponse(t *testing.T) {
+	flickrURL := "http://farm1.static.flickr.com/1/2_3_b.jpg"
+	for _, d := range []struct {
+		URL         string
+		Status      int
+		ContentType string
+		FinalURL    string
+		OK          bool
+	}{
+		{"http://example.com/a.jpg", 200, "image/jpeg", "http://example.com/a.jpg", true},
+		{"http://example.com/a.webp", 200, "Image/WebP; charset=binary", "http://example.com/a.webp", true},
+		{"http://example.com/a.jpg", 404, "image/jpeg", "http://example.com/a.jpg", false},
+		{"http://example.com/a.jpg", 200, "text/html", "http://example.com/a.jpg", false},
+		{flickrURL, 200, "image/gif", "http://s.yimg.com/pw/images/photo_unavailable.gif", false},
+		{flickrURL, 200, "image/jpeg", flickrURL, true},
+	} {
+		err := CheckImageResponse(d.URL, d.Status, d.ContentType, d.FinalURL)
+		if (err == nil) != d.OK {
+			t.Errorf("%s %d %s: got error %v, expects ok %v", d.URL, d.Status, d.ContentType, err, d.OK)
+		}
+	}
+}
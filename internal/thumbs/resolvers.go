@@ -0,0 +1,225 @@
+package thumbs
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/FreeFeed/clio-restore/internal/clio"
+)
+
+// Common patterns
+var (
+	// FFMediaURLRe matches URLs of images stored on FriendFeed, the first
+	// submatch is the image ID
+	FFMediaURLRe = regexp.MustCompile(`http://(?:(?:m\.)?friendfeed-media\.com|i\.friendfeed\.com)/([0-9a-f]+)`)
+	// FlickrImageRe matches URLs of Flickr static images
+	FlickrImageRe = regexp.MustCompile(`https?://[^/]+\.static\.?flickr\.com/.+_[a-z]\.jpg`)
+
+	ffEntryRe        = regexp.MustCompile(`^http://friendfeed\.com/e/`)
+	instagramImageRe = regexp.MustCompile(`http://[^/]+\.instagram\.com/`)
+	instagramIDRe    = regexp.MustCompile(`http://(?:instagr\.am|instagram.com)/p/([^/]+)`)
+)
+
+func prefixRe(prefix string) *regexp.Regexp {
+	return regexp.MustCompile("^" + regexp.QuoteMeta(prefix))
+}
+
+func image(urls ...string) *Image { return &Image{URLs: urls} }
+
+// Built-in thumbnail resolvers. The URL rewrite resolvers are defined by Rules
+// and placed between the player and the default resolvers.
+var (
+	ffMediaResolver = &Resolver{
+		Name:    "friendfeed-media",
+		Match:   Match{Link: FFMediaURLRe},
+		Resolve: func(e *Entry, t Thumbnail) *Image { return image(t.Link) },
+	}
+
+	playerResolver = &Resolver{
+		Name:    "player",
+		Match:   Match{Player: true},
+		Resolve: func(e *Entry, t Thumbnail) *Image { return nil },
+	}
+
+	defaultResolver = &Resolver{
+		Name:    "default",
+		Resolve: func(e *Entry, t Thumbnail) *Image { return image(t.Link, t.URL) },
+	}
+)
+
+// Entry rules. The order matters: the first applied rule wins.
+var (
+	// All images are of the well-known types
+	knownOnlyRule = &EntryRule{
+		Name: "known-only",
+		Apply: func(r *Registry, e *Entry) (res Result, ok bool) {
+			names := []string{ffMediaResolver.Name, "twitpic", "imgur"}
+			for _, t := range e.Thumbnails {
+				if !ffEntryRe.MatchString(t.Link) && r.Find(e, t, names) == nil {
+					return
+				}
+			}
+			return Result{Images: r.ResolveThumbnails(e, e.Thumbnails, names)}, true
+		},
+	}
+
+	deadHostsRule = &EntryRule{
+		Name: "dead-hosts",
+		Apply: func(r *Registry, e *Entry) (res Result, ok bool) {
+			return Result{}, r.IsDead(e.ViaURL)
+		},
+	}
+
+	// Bookmarklet or direct post with the local thumbnails of the same link
+	bookmarkletRule = &EntryRule{
+		Name: "bookmarklet",
+		Apply: func(r *Registry, e *Entry) (res Result, ok bool) {
+			if e.ViaURL != "http://friendfeed.com/share/bookmarklet" && e.ViaURL != clio.DefaultViaURL {
+				return
+			}
+			link := e.Thumbnails[0].Link
+			for _, t := range e.Thumbnails {
+				if t.Link != link || !FFMediaURLRe.MatchString(t.URL) {
+					return
+				}
+			}
+
+			if instagramImageRe.MatchString(link) {
+				res = r.ResolveAfter("bookmarklet", e)
+			} else {
+				// Use local thumbnails
+				for _, t := range e.Thumbnails {
+					res.Images = append(res.Images, *image(t.URL))
+				}
+			}
+			if !e.HasLink(link) {
+				// Add link if body doesn't contan it
+				res.AppendLink = link
+			}
+			return res, true
+		},
+	}
+
+	fotkiRule    = viaRule("fotki.yandex")
+	picasaRule   = viaRule("picasa")
+	youtubeRule  = singleThumbRule("youtube", prefixRe("http://www.youtube.com/watch"), skipIfLink(nil))
+	vimeoRule    = singleThumbRule("vimeo", prefixRe("http://vimeo.com/"), skipIfLink(func(e *Entry, t Thumbnail) bool { return e.HasLink(t.Link) }))
+	behanceRule  = singleThumbRule("behance", prefixRe("http://behance.vo.llnwd.net/"), skipIfLink(hasLinkWithPrefix("http://www.behance.net/gallery/")))
+	vimeoCDNRule = singleThumbRule("vimeo-cdn", prefixRe("http://b.vimeocdn.com/ts/"), skipIfLink(hasLinkWithPrefix("http://vimeo.com/", "https://vimeo.com/")))
+
+	instagramRule = singleThumbRule("instagram", instagramImageRe, func(e *Entry, t Thumbnail) (res Result, ok bool) {
+		for _, l := range e.Links {
+			if m := instagramIDRe.FindStringSubmatch(l); m != nil {
+				bigImageURL := "https://instagram.com/p/" + m[1] + "/media/?size=l"
+				return Result{Images: []Image{*image(bigImageURL, t.URL)}}, true
+			}
+		}
+		return
+	})
+)
+
+// viaRule applies the via-specific resolver to all entry thumbnails
+func viaRule(name string) *EntryRule {
+	return &EntryRule{
+		Name: name,
+		Apply: func(r *Registry, e *Entry) (res Result, ok bool) {
+			resolver := r.Resolver(name)
+			if resolver == nil || resolver.Match.Via == nil || !resolver.Match.Via.MatchString(e.ViaURL) {
+				return
+			}
+			return Result{Images: r.ResolveThumbnails(e, e.Thumbnails, []string{name})}, true
+		},
+	}
+}
+
+// singleThumbRule applies to the entries with the only thumbnail whose link matches linkRe
+func singleThumbRule(name string, linkRe *regexp.Regexp, apply func(e *Entry, t Thumbnail) (Result, bool)) *EntryRule {
+	return &EntryRule{
+		Name: name,
+		Apply: func(r *Registry, e *Entry) (res Result, ok bool) {
+			if len(e.Thumbnails) != 1 || !linkRe.MatchString(e.Thumbnails[0].Link) {
+				return
+			}
+			return apply(e, e.Thumbnails[0])
+		},
+	}
+}
+
+// skipIfLink skips entry thumbnails if cond is nil or returns true
+func skipIfLink(cond func(e *Entry, t Thumbnail) bool) func(e *Entry, t Thumbnail) (Result, bool) {
+	return func(e *Entry, t Thumbnail) (Result, bool) {
+		return Result{}, cond == nil || cond(e, t)
+	}
+}
+
+func hasLinkWithPrefix(prefixes ...string) func(e *Entry, t Thumbnail) bool {
+	return func(e *Entry, t Thumbnail) bool {
+		for _, l := range e.Links {
+			for _, p := range prefixes {
+				if strings.HasPrefix(l, p) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// ResolveAfter resolves entry thumbnails using only the rules after the named one
+func (r *Registry) ResolveAfter(ruleName string, e *Entry) Result {
+	for i, rule := range r.Rules {
+		if rule.Name == ruleName {
+			rest := &Registry{Rules: r.Rules[i+1:], Resolvers: r.Resolvers, DeadHosts: r.DeadHosts}
+			return rest.Resolve(e)
+		}
+	}
+	return r.Resolve(e)
+}
+
+// New returns the registry with all built-in entry rules and resolvers and
+// with the rewrite resolvers and dead hosts from rules
+func New(rules *Rules) (*Registry, error) {
+	rewrites, err := rules.Resolvers()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{
+		Rules: []*EntryRule{
+			knownOnlyRule,
+			deadHostsRule,
+			bookmarkletRule,
+			fotkiRule,
+			picasaRule,
+			youtubeRule,
+			vimeoRule,
+			instagramRule,
+			behanceRule,
+			vimeoCDNRule,
+		},
+		DeadHosts: rules.DeadHosts,
+	}
+	r.Resolvers = append(r.Resolvers, ffMediaResolver, playerResolver)
+	r.Resolvers = append(r.Resolvers, rewrites...)
+	r.Resolvers = append(r.Resolvers, defaultResolver)
+	return r, nil
+}
+
+// Load returns the registry with the built-in rules merged with rules from
+// the file (see LoadRules)
+func Load(fileName string) (*Registry, error) {
+	rules, err := LoadRules(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return New(rules)
+}
+
+// Default returns the registry with the built-in rules
+func Default() *Registry {
+	r, err := New(DefaultRules())
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
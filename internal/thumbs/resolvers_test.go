@@ -0,0 +1,213 @@
+package thumbs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/FreeFeed/clio-restore/internal/clio"
+)
+
+func imgs(urls ...[]string) (res []Image) {
+	for _, u := range urls {
+		res = append(res, Image{URLs: u})
+	}
+	return
+}
+
+type resolveTest struct {
+	Name   string
+	Entry  Entry
+	Result Result
+}
+
+func testResolve(t *testing.T, data []resolveTest) {
+	r := Default()
+	for _, d := range data {
+		e := d.Entry
+		res := r.Resolve(&e)
+		if !reflect.DeepEqual(res, d.Result) {
+			t.Errorf("%s: got %+v, expects %+v", d.Name, res, d.Result)
+		}
+	}
+}
+
+func TestResolveCommon(t *testing.T) {
+	testResolve(t, []resolveTest{
+		{
+			"no thumbnails",
+			Entry{ViaURL: clio.DefaultViaURL},
+			Result{},
+		},
+		{
+			"known only",
+			Entry{ViaURL: "http://example.com/", Thumbnails: []Thumbnail{
+				{URL: "http://m.friendfeed-media.com/abc", Link: "http://m.friendfeed-media.com/0123abc"},
+				{URL: "http://twitpic.com/show/thumb/xyz", Link: "http://twitpic.com/xyz"},
+				{URL: "http://i.imgur.com/QWEs.jpg", Link: "http://friendfeed.com/e/123"},
+				{URL: "http://example.com/thumb.jpg", Link: "http://friendfeed.com/e/123"},
+			}},
+			Result{Images: imgs(
+				[]string{"http://m.friendfeed-media.com/0123abc"},
+				[]string{"http://twitpic.com/show/large/xyz"},
+				[]string{"http://i.imgur.com/QWE.jpg"},
+			)},
+		},
+		{
+			"dead service",
+			Entry{ViaURL: "http://www.zooomr.com/photos/", Thumbnails: []Thumbnail{
+				{URL: "http://example.com/thumb.jpg", Link: "http://example.com/image.jpg"},
+			}},
+			Result{},
+		},
+		{
+			"common case",
+			Entry{ViaURL: "http://example.com/", Thumbnails: []Thumbnail{
+				{URL: "http://example.com/player.jpg", Link: "http://example.com/video", Player: true},
+				{URL: "http://pbs.twimg.com/media/A.jpg:thumb", Link: "http://pbs.twimg.com/media/A.jpg"},
+				{URL: "http://p.twimg.com/B.jpg:thumb", Link: "http://p.twimg.com/B.jpg"},
+				{URL: "http://asset-a.soup.io/asset/1/a_400.gif", Link: "http://user.soup.io/post/1"},
+				{URL: "http://example.com/thumb.jpg", Link: "http://example.com/image.jpg"},
+			}},
+			Result{Images: imgs(
+				[]string{"http://pbs.twimg.com/media/A.jpg:large", "http://pbs.twimg.com/media/A.jpg:thumb"},
+				[]string{"https://pbs.twimg.com/media/B.jpg:large", "http://p.twimg.com/B.jpg:thumb"},
+				[]string{"http://asset-a.soup.io/asset/1/a.gif"},
+				[]string{"http://example.com/image.jpg", "http://example.com/thumb.jpg"},
+			)},
+		},
+	})
+}
+
+func TestResolveBookmarklet(t *testing.T) {
+	testResolve(t, []resolveTest{
+		{
+			"bookmarklet",
+			Entry{ViaURL: "http://friendfeed.com/share/bookmarklet", Thumbnails: []Thumbnail{
+				{URL: "http://m.friendfeed-media.com/1", Link: "http://example.com/page"},
+				{URL: "http://m.friendfeed-media.com/2", Link: "http://example.com/page"},
+			}},
+			Result{
+				Images:     imgs([]string{"http://m.friendfeed-media.com/1"}, []string{"http://m.friendfeed-media.com/2"}),
+				AppendLink: "http://example.com/page",
+			},
+		},
+		{
+			"bookmarklet with link in body",
+			Entry{ViaURL: clio.DefaultViaURL, Links: []string{"http://example.com/page"}, Thumbnails: []Thumbnail{
+				{URL: "http://m.friendfeed-media.com/1", Link: "http://example.com/page"},
+			}},
+			Result{Images: imgs([]string{"http://m.friendfeed-media.com/1"})},
+		},
+		{
+			"bookmarklet with instagram image",
+			Entry{ViaURL: clio.DefaultViaURL, Links: []string{"http://instagram.com/p/ID1/"}, Thumbnails: []Thumbnail{
+				{URL: "http://m.friendfeed-media.com/1", Link: "http://distilleryimage1.instagram.com/a.jpg"},
+			}},
+			Result{
+				Images:     imgs([]string{"https://instagram.com/p/ID1/media/?size=l", "http://m.friendfeed-media.com/1"}),
+				AppendLink: "http://distilleryimage1.instagram.com/a.jpg",
+			},
+		},
+	})
+}
+
+func TestResolveFotki(t *testing.T) {
+	testResolve(t, []resolveTest{
+		{
+			"fotki.yandex",
+			Entry{ViaURL: "http://fotki.yandex.ru/users/user/", Thumbnails: []Thumbnail{
+				{URL: "http://img-fotki.yandex.ru/get/1/user.1/0_1_S", Link: "http://fotki.yandex.ru/users/user/view/1/"},
+				{URL: "http://example.com/thumb.jpg", Link: "http://example.com/image.jpg"},
+			}},
+			Result{Images: imgs([]string{"http://img-fotki.yandex.ru/get/1/user.1/0_1_orig"})},
+		},
+	})
+}
+
+func TestResolvePicasa(t *testing.T) {
+	testResolve(t, []resolveTest{
+		{
+			"picasa",
+			Entry{ViaURL: "http://picasaweb.google.com/user", Links: []string{"http://picasaweb.google.com/user/1"}, Thumbnails: []Thumbnail{
+				{URL: "http://lh3.ggpht.com/a/s144/1.jpg", Link: "http://picasaweb.google.com/user/1"},
+				{URL: "http://lh3.ggpht.com/a/s144/2.jpg", Link: "http://picasaweb.google.com/user/2"},
+			}},
+			Result{Images: imgs([]string{"http://lh3.ggpht.com/a/1.jpg"})},
+		},
+		{
+			"picasa other sizes",
+			Entry{ViaURL: "http://picasaweb.google.com/user", Links: []string{"http://picasaweb.google.com/user/1", "http://picasaweb.google.com/user/2"}, Thumbnails: []Thumbnail{
+				{URL: "http://lh4.ggpht.com/a/s1600/1.jpg", Link: "http://picasaweb.google.com/user/1"},
+				{URL: "http://lh4.ggpht.com/a/w500-h300/2.jpg", Link: "http://picasaweb.google.com/user/2"},
+			}},
+			Result{Images: imgs([]string{"http://lh4.ggpht.com/a/1.jpg"}, []string{"http://lh4.ggpht.com/a/2.jpg"})},
+		},
+		{
+			"picasa without size",
+			Entry{ViaURL: "http://picasaweb.google.com/user", Links: []string{"http://picasaweb.google.com/user/1"}, Thumbnails: []Thumbnail{
+				{URL: "http://lh4.ggpht.com/a/b/1.jpg", Link: "http://picasaweb.google.com/user/1"},
+			}},
+			Result{Images: imgs([]string{"http://lh4.ggpht.com/a/b/1.jpg"})},
+		},
+	})
+}
+
+func TestResolveVideo(t *testing.T) {
+	testResolve(t, []resolveTest{
+		{
+			"youtube",
+			Entry{ViaURL: "http://example.com/", Thumbnails: []Thumbnail{
+				{URL: "http://i.ytimg.com/vi/1/default.jpg", Link: "http://www.youtube.com/watch?v=1"},
+			}},
+			Result{},
+		},
+		{
+			"vimeo in body",
+			Entry{ViaURL: "http://example.com/", Links: []string{"http://vimeo.com/1"}, Thumbnails: []Thumbnail{
+				{URL: "http://b.vimeocdn.com/1.jpg", Link: "http://vimeo.com/1"},
+			}},
+			Result{},
+		},
+		{
+			"vimeo not in body",
+			Entry{ViaURL: "http://example.com/", Thumbnails: []Thumbnail{
+				{URL: "http://b.vimeocdn.com/1.jpg", Link: "http://vimeo.com/1"},
+			}},
+			Result{Images: imgs([]string{"http://vimeo.com/1", "http://b.vimeocdn.com/1.jpg"})},
+		},
+		{
+			"vimeo cdn",
+			Entry{ViaURL: "http://example.com/", Links: []string{"https://vimeo.com/1"}, Thumbnails: []Thumbnail{
+				{URL: "http://b.vimeocdn.com/ts/1.jpg", Link: "http://b.vimeocdn.com/ts/1.jpg"},
+			}},
+			Result{},
+		},
+	})
+}
+
+func TestResolveBehance(t *testing.T) {
+	testResolve(t, []resolveTest{
+		{
+			"behance",
+			Entry{ViaURL: "http://example.com/", Links: []string{"http://www.behance.net/gallery/1"}, Thumbnails: []Thumbnail{
+				{URL: "http://behance.vo.llnwd.net/1.jpg", Link: "http://behance.vo.llnwd.net/1.jpg"},
+			}},
+			Result{},
+		},
+	})
+}
+
+func TestResolveFlickr(t *testing.T) {
+	testResolve(t, []resolveTest{
+		{
+			"flickr",
+			Entry{ViaURL: "http://www.flickr.com/", Thumbnails: []Thumbnail{
+				{URL: "http://farm1.static.flickr.com/1/2_3_s.jpg", Link: "http://www.flickr.com/photos/user/2/"},
+			}},
+			Result{Images: []Image{{
+				URLs:   []string{"http://farm1.static.flickr.com/1/2_3_b.jpg"},
+				Lookup: &Lookup{Service: "flickr", PageURL: "http://www.flickr.com/photos/user/2/"},
+			}}},
+		},
+	})
+}
@@ -0,0 +1,264 @@
+package thumbs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Rules is a declarative part of the thumbnails resolution: URL rewrite rules
+// and dead hosts. Rules are loaded from the JSON file (see LoadRules), so
+// operators can fix a broken image host without rebuilding the program.
+type Rules struct {
+	// DeadHosts are hosts that are no longer exist. Thumbnails of entries
+	// posted via these hosts are skipped and image URLs of these hosts are
+	// not fetched. Host also matches all its subdomains.
+	DeadHosts []string  `json:"deadHosts"`
+	Rewrites  []Rewrite `json:"rewrites"`
+}
+
+// Rewrite is a thumbnail URL rewrite rule. The rule matches thumbnail if all
+// defined patterns match: Via the entry via URL, URL the thumbnail URL and Link
+// the thumbnail link.
+//
+// Replace and Fallbacks are templates of the image URLs in order of
+// preference. Templates can contain submatches of the Field pattern in the
+// regexp.Expand syntax ($1, ${name}) and the {url} and {link} placeholders for
+// the thumbnail URL and link.
+type Rewrite struct {
+	Name      string   `json:"name"`
+	Priority  int      `json:"priority"` // rules with higher priority are tried first
+	Disabled  bool     `json:"disabled"` // disables the rule with the same name
+	Via       string   `json:"via"`
+	URL       string   `json:"url"`
+	Link      string   `json:"link"`
+	Field     string   `json:"field"`  // "url" or "link", default is "url" if URL is defined
+	InBody    bool     `json:"inBody"` // thumbnail link must present in the entry body
+	Replace   string   `json:"replace"`
+	Fallbacks []string `json:"fallbacks"`
+	Lookup    string   `json:"lookup"` // service to find image URLs if all URLs fail (i.e. "flickr")
+}
+
+// Built-in rules
+const defaultRulesJSON = `{
+	"deadHosts": ["filmfeed.ru", "www.zooomr.com", "meme.yahoo.com"],
+	"rewrites": [
+		{
+			"name": "twitpic", "priority": 90,
+			"url": "^(http://twitpic\\.com/show/)thumb/(.*)$",
+			"replace": "${1}large/${2}"
+		},
+		{
+			"name": "twitter-media", "priority": 80,
+			"link": "^http://pbs\\.twimg\\.com/media/",
+			"replace": "{link}:large", "fallbacks": ["{url}"]
+		},
+		{
+			"name": "twitter-old-media", "priority": 70,
+			"link": "^http://p\\.twimg\\.com/(.*)$",
+			"replace": "https://pbs.twimg.com/media/${1}:large", "fallbacks": ["{url}"]
+		},
+		{
+			"name": "imgur", "priority": 60,
+			"url": "http://(?:i\\.)?imgur\\.com/(\\w+?)s\\.jpg",
+			"replace": "http://i.imgur.com/${1}.jpg"
+		},
+		{
+			"name": "soup", "priority": 50,
+			"url": "^(.*http://asset-\\w\\.soup\\.io/asset/.*?)_400\\.gif(.*)$",
+			"replace": "${1}.gif${2}"
+		},
+		{
+			"name": "soup-asset", "priority": 49,
+			"url": "http://asset-\\w\\.soup\\.io/asset/",
+			"replace": "{url}"
+		},
+		{
+			"name": "flickr", "priority": 40,
+			"url": "^(https?://[^/]+\\.static\\.?flickr\\.com/.+)_[a-z]\\.jpg$",
+			"replace": "${1}_b.jpg", "lookup": "flickr"
+		},
+		{
+			"name": "fotki.yandex", "priority": 30,
+			"via": "^http://fotki\\.yandex\\.ru/users/",
+			"url": "^(http://img-fotki\\.yandex\\.ru/get/.*).$",
+			"link": "^http://fotki\\.yandex\\.ru/users/",
+			"replace": "${1}orig"
+		},
+		{
+			"name": "picasa", "priority": 20,
+			"via": "^http://picasaweb\\.google\\.com/", "inBody": true,
+			"url": "^(http://lh\\d+\\.ggpht\\.com/.*?)(?:/(?:s\\d+|w\\d+-h\\d+)(?:-c)?)?(/[^/]*)$",
+			"replace": "${1}${2}"
+		}
+	]
+}`
+
+// DefaultRules returns the built-in rules
+func DefaultRules() *Rules {
+	rules, err := ParseRules([]byte(defaultRulesJSON))
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// ParseRules parses JSON rules
+func ParseRules(data []byte) (*Rules, error) {
+	rules := new(Rules)
+	if err := json.Unmarshal(data, rules); err != nil {
+		return nil, errors.Annotate(err, "cannot parse thumbnail rules")
+	}
+	return rules, nil
+}
+
+// LoadRules loads rules from the file and merges them with the built-in ones.
+// If file is not exists, the built-in rules are returned.
+func LoadRules(fileName string) (*Rules, error) {
+	rules := DefaultRules()
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot read thumbnail rules")
+	}
+	fileRules, err := ParseRules(data)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid rules file %s", fileName)
+	}
+	return rules.Merge(fileRules), nil
+}
+
+// Merge returns the union of r and o. Rewrites of o replace the ones of r with
+// the same name; disabled rewrites are removed.
+func (r *Rules) Merge(o *Rules) *Rules {
+	res := &Rules{DeadHosts: append(append([]string(nil), r.DeadHosts...), o.DeadHosts...)}
+	overrides := make(map[string]bool)
+	for _, rw := range o.Rewrites {
+		overrides[rw.Name] = true
+	}
+	for _, rw := range r.Rewrites {
+		if !overrides[rw.Name] {
+			res.Rewrites = append(res.Rewrites, rw)
+		}
+	}
+	for _, rw := range o.Rewrites {
+		if !rw.Disabled {
+			res.Rewrites = append(res.Rewrites, rw)
+		}
+	}
+	return res
+}
+
+// isDeadHost returns true if host of the given URL is in hosts list
+func isDeadHost(hosts []string, u string) bool {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(pu.Hostname())
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolvers compiles rewrites to the thumbnail resolvers ordered by priority
+func (r *Rules) Resolvers() ([]*Resolver, error) {
+	rws := append([]Rewrite(nil), r.Rewrites...)
+	sort.SliceStable(rws, func(i, j int) bool { return rws[i].Priority > rws[j].Priority })
+
+	var res []*Resolver
+	for _, rw := range rws {
+		if rw.Disabled {
+			continue
+		}
+		resolver, err := rw.compile()
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid rewrite rule %q", rw.Name)
+		}
+		res = append(res, resolver)
+	}
+	return res, nil
+}
+
+func (rw *Rewrite) compile() (*Resolver, error) {
+	if rw.Name == "" {
+		return nil, errors.New("rule name is required")
+	}
+	r := &Resolver{Name: rw.Name}
+	for _, p := range []struct {
+		re  **regexp.Regexp
+		src string
+	}{{&r.Match.Via, rw.Via}, {&r.Match.URL, rw.URL}, {&r.Match.Link, rw.Link}} {
+		if p.src == "" {
+			continue
+		}
+		re, err := regexp.Compile(p.src)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		*p.re = re
+	}
+
+	field := rw.Field
+	if field == "" {
+		field = "url"
+		if r.Match.URL == nil {
+			field = "link"
+		}
+	}
+	var fieldRe *regexp.Regexp
+	switch field {
+	case "url":
+		fieldRe = r.Match.URL
+	case "link":
+		fieldRe = r.Match.Link
+	default:
+		return nil, errors.Errorf("unknown field %q", rw.Field)
+	}
+	if fieldRe == nil {
+		return nil, errors.Errorf("%s pattern is required", field)
+	}
+
+	inBody, lookup := rw.InBody, rw.Lookup
+	templates := append([]string{rw.Replace}, rw.Fallbacks...)
+	r.Resolve = func(e *Entry, t Thumbnail) *Image {
+		if inBody && !e.HasLink(t.Link) {
+			return nil
+		}
+		src := t.URL
+		if field == "link" {
+			src = t.Link
+		}
+		m := fieldRe.FindStringSubmatchIndex(src)
+		if m == nil {
+			return nil
+		}
+		ph := strings.NewReplacer("{url}", t.URL, "{link}", t.Link)
+		img := new(Image)
+		for _, tpl := range templates {
+			if u := ph.Replace(string(fieldRe.ExpandString(nil, tpl, src, m))); u != "" {
+				img.URLs = append(img.URLs, u)
+			}
+		}
+		if len(img.URLs) == 0 {
+			return nil
+		}
+		if lookup != "" {
+			img.Lookup = &Lookup{Service: lookup, PageURL: t.Link}
+		}
+		return img
+	}
+	return r, nil
+}
@@ -0,0 +1,112 @@
+package thumbs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testRulesJSON = `{
+	"deadHosts": ["dead.example.com"],
+	"rewrites": [
+		{"name": "imgur", "disabled": true},
+		{
+			"name": "example", "priority": 100,
+			"url": "^(http://img\\.example\\.com/.*)_t\\.jpg$",
+			"replace": "${1}_big.jpg", "fallbacks": ["{url}", "{link}"]
+		}
+	]
+}`
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thumbs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "thumb-rules.json")
+
+	// No file: built-in rules
+	r, err := Load(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resolverNames(r), resolverNames(Default())) {
+		t.Error("Load without file: expects built-in resolvers")
+	}
+
+	if err := ioutil.WriteFile(fileName, []byte(testRulesJSON), 0666); err != nil {
+		t.Fatal(err)
+	}
+	r, err = Load(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []struct {
+		Name   string
+		Entry  Entry
+		Result Result
+	}{
+		{
+			"custom rule",
+			Entry{ViaURL: "http://example.com/", Thumbnails: []Thumbnail{
+				{URL: "http://img.example.com/1_t.jpg", Link: "http://example.com/1"},
+			}},
+			Result{Images: imgs([]string{"http://img.example.com/1_big.jpg", "http://img.example.com/1_t.jpg", "http://example.com/1"})},
+		},
+		{
+			"disabled rule",
+			Entry{ViaURL: "http://example.com/", Thumbnails: []Thumbnail{
+				{URL: "http://i.imgur.com/QWEs.jpg", Link: "http://imgur.com/QWE"},
+			}},
+			Result{Images: imgs([]string{"http://imgur.com/QWE", "http://i.imgur.com/QWEs.jpg"})},
+		},
+		{
+			"built-in dead host",
+			Entry{ViaURL: "http://meme.yahoo.com/user/", Thumbnails: []Thumbnail{
+				{URL: "http://example.com/thumb.jpg", Link: "http://example.com/image.jpg"},
+			}},
+			Result{},
+		},
+		{
+			"dead host URLs",
+			Entry{ViaURL: "http://example.com/", Thumbnails: []Thumbnail{
+				{URL: "http://example.com/thumb.jpg", Link: "http://img.dead.example.com/image.jpg"},
+				{URL: "http://dead.example.com/thumb.jpg", Link: "http://dead.example.com/image.jpg"},
+			}},
+			Result{Images: imgs([]string{"http://example.com/thumb.jpg"})},
+		},
+	} {
+		e := d.Entry
+		res := r.Resolve(&e)
+		if !reflect.DeepEqual(res, d.Result) {
+			t.Errorf("%s: got %+v, expects %+v", d.Name, res, d.Result)
+		}
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, data := range []string{
+		`{"rewrites": [{"name": "bad", "url": "(", "replace": "x"}]}`,
+		`{"rewrites": [{"name": "bad", "field": "link", "url": "x", "replace": "x"}]}`,
+		`{"rewrites": [{"url": "x", "replace": "x"}]}`,
+	} {
+		rules, err := ParseRules([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(rules); err == nil {
+			t.Errorf("New with %s: expects error", data)
+		}
+	}
+}
+
+func resolverNames(r *Registry) (names []string) {
+	for _, res := range r.Resolvers {
+		names = append(names, res.Name)
+	}
+	return
+}
@@ -0,0 +1,187 @@
+// Package thumbs resolves FriendFeed entry thumbnails to the full-size image
+// URLs. Each supported service has its own resolver in the Registry.
+package thumbs
+
+import (
+	"regexp"
+
+	"github.com/FreeFeed/clio-restore/internal/clio"
+)
+
+// Entry is an entry data used by resolvers
+type Entry struct {
+	ViaURL     string
+	Links      []string // links from the entry body
+	Thumbnails []Thumbnail
+}
+
+// Thumbnail is an entry thumbnail
+type Thumbnail struct {
+	URL    string
+	Link   string
+	Player bool
+}
+
+// FromEntry creates Entry from the initialized clio.Entry
+func FromEntry(e *clio.Entry) *Entry {
+	r := &Entry{ViaURL: e.Via.URL, Links: e.Links}
+	for _, t := range e.Thumbnails {
+		r.Thumbnails = append(r.Thumbnails, Thumbnail{URL: t.URL, Link: t.Link, Player: t.Player != nil})
+	}
+	return r
+}
+
+// HasLink returns true if entry body contains link
+func (e *Entry) HasLink(link string) bool {
+	for _, l := range e.Links {
+		if l == link {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is a resolution result of the entry thumbnails
+type Result struct {
+	Images []Image
+	// AppendLink, if not empty, should be appended to the post body
+	AppendLink string
+}
+
+// Image is a full-size image to create from the one of candidate URLs
+type Image struct {
+	URLs []string // candidate URLs in order of preference
+	// Lookup, if not nil, is used to obtain more candidate URLs when all URLs fail
+	Lookup *Lookup
+}
+
+// Lookup is a request to find image URLs using the service API
+type Lookup struct {
+	Service string // i.e. "flickr"
+	PageURL string
+}
+
+// Match declares what resolver matches. All non-nil patterns must match.
+type Match struct {
+	Via    *regexp.Regexp // entry via URL
+	URL    *regexp.Regexp // thumbnail URL
+	Link   *regexp.Regexp // thumbnail link
+	Player bool           // thumbnail must have player
+}
+
+// Matches returns true if thumbnail of entry e matches m
+func (m *Match) Matches(e *Entry, t Thumbnail) bool {
+	return (m.Via == nil || m.Via.MatchString(e.ViaURL)) &&
+		(m.URL == nil || m.URL.MatchString(t.URL)) &&
+		(m.Link == nil || m.Link.MatchString(t.Link)) &&
+		(!m.Player || t.Player)
+}
+
+// Resolver resolves a single thumbnail
+type Resolver struct {
+	Name  string
+	Match Match
+	// Resolve returns the image to create or nil to skip the thumbnail
+	Resolve func(e *Entry, t Thumbnail) *Image
+}
+
+// EntryRule processes the whole entry before the per-thumbnail resolution.
+// If rule returns ok = true, its result is final; the empty result means
+// "skip all entry thumbnails".
+type EntryRule struct {
+	Name  string
+	Apply func(r *Registry, e *Entry) (res Result, ok bool)
+}
+
+// Registry is a set of entry rules and thumbnail resolvers
+type Registry struct {
+	Rules     []*EntryRule // tried in order, the first that returns ok wins
+	Resolvers []*Resolver  // tried in order for each thumbnail, the first matched wins
+	DeadHosts []string     // image URLs of these hosts are never used
+}
+
+// Resolve resolves entry thumbnails
+func (r *Registry) Resolve(e *Entry) Result {
+	res := r.resolve(e)
+
+	// Remove URLs of the dead hosts
+	images := res.Images[:0]
+	for _, img := range res.Images {
+		urls := img.URLs[:0:0]
+		for _, u := range img.URLs {
+			if !r.IsDead(u) {
+				urls = append(urls, u)
+			}
+		}
+		img.URLs = urls
+		if len(img.URLs) > 0 || img.Lookup != nil {
+			images = append(images, img)
+		}
+	}
+	if len(images) == 0 {
+		images = nil
+	}
+	res.Images = images
+	return res
+}
+
+func (r *Registry) resolve(e *Entry) Result {
+	if len(e.Thumbnails) == 0 {
+		return Result{}
+	}
+	for _, rule := range r.Rules {
+		if res, ok := rule.Apply(r, e); ok {
+			return res
+		}
+	}
+	return Result{Images: r.ResolveThumbnails(e, e.Thumbnails, nil)}
+}
+
+// IsDead returns true if host of the given URL is dead
+func (r *Registry) IsDead(u string) bool { return isDeadHost(r.DeadHosts, u) }
+
+// ResolveThumbnails resolves each thumbnail by the first matched resolver.
+// If names is not empty, only resolvers with these names are used.
+func (r *Registry) ResolveThumbnails(e *Entry, ts []Thumbnail, names []string) (images []Image) {
+	for _, t := range ts {
+		if res := r.Find(e, t, names); res != nil {
+			if img := res.Resolve(e, t); img != nil {
+				images = append(images, *img)
+			}
+		}
+	}
+	return
+}
+
+// Find returns the first resolver that matches thumbnail. If names is not
+// empty, only resolvers with these names are used.
+func (r *Registry) Find(e *Entry, t Thumbnail, names []string) *Resolver {
+	for _, res := range r.Resolvers {
+		if len(names) > 0 && !contains(names, res.Name) {
+			continue
+		}
+		if res.Match.Matches(e, t) {
+			return res
+		}
+	}
+	return nil
+}
+
+// Resolver returns resolver by name or nil if not found
+func (r *Registry) Resolver(name string) *Resolver {
+	for _, res := range r.Resolvers {
+		if res.Name == name {
+			return res
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}